@@ -0,0 +1,61 @@
+package cache
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"time"
+)
+
+// AtomicCache 先 Get 再 Set 的计数器、"谁先写谁算数"之类的逻辑中间会被别人插进来，这里的操作都是原子的。
+// BulidinMapCache（包括 ShardedCache）在锁里做，RedisCache 用原生命令或者 lua 脚本
+type AtomicCache interface {
+	Cache
+	// SetNX key 不存在的时候才写，返回有没有写进去
+	SetNX(ctx context.Context, key string, val any, expiration time.Duration) (bool, error)
+	// GetOrSet key 存在就返回现在的值，loaded 为true；不存在就写入 val 并返回 val，loaded 为false
+	GetOrSet(ctx context.Context, key string, val any, expiration time.Duration) (actual any, loaded bool, err error)
+	// GetWithVersion 值和它的版本号，版本号只能拿来传给 CompareAndSwap，不要对它做别的假设
+	GetWithVersion(ctx context.Context, key string) (any, uint64, error)
+	// CompareAndSwap 版本号和 GetWithVersion 拿到的一样（中间没人改过）才写，返回有没有写进去。
+	// version 传0表示key必须不存在
+	CompareAndSwap(ctx context.Context, key string, version uint64, val any, expiration time.Duration) (bool, error)
+	// IncrBy key 不存在的时候从0开始加，不会设置过期时间，已有的过期时间保持不变，值不是整数返回 ErrCacheValueType，
+	// 结果超出 int64 返回 ErrCacheValueOverflow，值不变。
+	// 计数器 Get 出来的类型和实现有关：本地缓存是 int64，RedisCache 是 string，读计数用 IncrBy(ctx, key, 0)
+	IncrBy(ctx context.Context, key string, delta int64) (int64, error)
+	DecrBy(ctx context.Context, key string, delta int64) (int64, error)
+}
+
+// toInt64 本地缓存 IncrBy 认识的值：整数、整数值的浮点数（快照用 JSON 恢复出来的）、数字字符串
+func toInt64(val any) (int64, bool) {
+	switch v := val.(type) {
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint:
+		return int64(v), v <= math.MaxInt64
+	case uint64:
+		return int64(v), v <= math.MaxInt64
+	case float64:
+		return int64(v), v == math.Trunc(v) && v >= math.MinInt64 && v < math.MaxInt64
+	case string:
+		n, err := strconv.ParseInt(v, 10, 64)
+		return n, err == nil
+	default:
+		return 0, false
+	}
+}
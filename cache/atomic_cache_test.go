@@ -0,0 +1,209 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAtomicCache(t *testing.T) {
+	mr := miniredis.RunT(t)
+	testCases := []struct {
+		name  string
+		cache func() AtomicCache
+	}{
+		{
+			name:  "buildin map",
+			cache: func() AtomicCache { return NewBuildinMapCache() },
+		},
+		{
+			name:  "sharded",
+			cache: func() AtomicCache { return newTestShardedCache(4) },
+		},
+		{
+			name: "redis",
+			cache: func() AtomicCache {
+				mr.FlushAll()
+				return NewRedisCache(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+			},
+		},
+		{
+			name: "redis codec",
+			cache: func() AtomicCache {
+				mr.FlushAll()
+				return NewRedisCache(redis.NewClient(&redis.Options{Addr: mr.Addr()}), RedisCacheWithCodec(JSONCodec{}))
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			c := tc.cache()
+
+			ok, err := c.SetNX(ctx, "nx", "v1", time.Minute)
+			require.NoError(t, err)
+			assert.True(t, ok)
+			ok, err = c.SetNX(ctx, "nx", "v2", time.Minute)
+			require.NoError(t, err)
+			assert.False(t, ok)
+			val, err := c.Get(ctx, "nx")
+			require.NoError(t, err)
+			assert.Equal(t, "v1", val)
+
+			val, loaded, err := c.GetOrSet(ctx, "gs", "v1", time.Minute)
+			require.NoError(t, err)
+			assert.False(t, loaded)
+			assert.Equal(t, "v1", val)
+			val, loaded, err = c.GetOrSet(ctx, "gs", "v2", time.Minute)
+			require.NoError(t, err)
+			assert.True(t, loaded)
+			assert.Equal(t, "v1", val)
+
+			// 版本号0表示key必须不存在
+			ok, err = c.CompareAndSwap(ctx, "cas", 0, "v1", time.Minute)
+			require.NoError(t, err)
+			assert.True(t, ok)
+			val, version, err := c.GetWithVersion(ctx, "cas")
+			require.NoError(t, err)
+			assert.Equal(t, "v1", val)
+			require.NoError(t, c.Set(ctx, "cas", "v2", time.Minute))
+			// 中间被别人改过了
+			ok, err = c.CompareAndSwap(ctx, "cas", version, "v3", time.Minute)
+			require.NoError(t, err)
+			assert.False(t, ok)
+			_, version, err = c.GetWithVersion(ctx, "cas")
+			require.NoError(t, err)
+			ok, err = c.CompareAndSwap(ctx, "cas", version, "v3", time.Minute)
+			require.NoError(t, err)
+			assert.True(t, ok)
+			val, err = c.Get(ctx, "cas")
+			require.NoError(t, err)
+			assert.Equal(t, "v3", val)
+			ok, err = c.CompareAndSwap(ctx, "cas", 0, "v4", time.Minute)
+			require.NoError(t, err)
+			assert.False(t, ok)
+
+			n, err := c.IncrBy(ctx, "counter", 5)
+			require.NoError(t, err)
+			assert.Equal(t, int64(5), n)
+			n, err = c.DecrBy(ctx, "counter", 2)
+			require.NoError(t, err)
+			assert.Equal(t, int64(3), n)
+			_, err = c.IncrBy(ctx, "nx", 1)
+			assert.ErrorIs(t, err, ErrCacheValueType)
+		})
+	}
+}
+
+func TestAtomicCache_Overflow(t *testing.T) {
+	// miniredis 溢出的时候不报错，RedisCache 靠 redis 自己报错，见 TestRedisCache_incrErr
+	testCases := []struct {
+		name  string
+		cache func() AtomicCache
+	}{
+		{
+			name:  "buildin map",
+			cache: func() AtomicCache { return NewBuildinMapCache() },
+		},
+		{
+			name:  "sharded",
+			cache: func() AtomicCache { return newTestShardedCache(4) },
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			c := tc.cache()
+			_, err := c.IncrBy(ctx, "counter", 3)
+			require.NoError(t, err)
+			// 溢出报错，不回绕，值不变
+			_, err = c.IncrBy(ctx, "counter", math.MaxInt64)
+			assert.ErrorIs(t, err, ErrCacheValueOverflow)
+			_, err = c.DecrBy(ctx, "counter", math.MinInt64)
+			assert.ErrorIs(t, err, ErrCacheValueOverflow)
+			n, err := c.IncrBy(ctx, "counter", 0)
+			require.NoError(t, err)
+			assert.Equal(t, int64(3), n)
+
+			n, err = c.DecrBy(ctx, "min", math.MaxInt64)
+			require.NoError(t, err)
+			assert.Equal(t, int64(-math.MaxInt64), n)
+			_, err = c.DecrBy(ctx, "min", 2)
+			assert.ErrorIs(t, err, ErrCacheValueOverflow)
+		})
+	}
+}
+
+func TestRedisCache_incrErr(t *testing.T) {
+	r := NewRedisCache(nil)
+	testCases := []struct {
+		name    string
+		err     error
+		wantErr error
+	}{
+		{name: "nil"},
+		{
+			name:    "not integer",
+			err:     errors.New("ERR value is not an integer or out of range"),
+			wantErr: ErrCacheValueType,
+		},
+		{
+			name:    "overflow",
+			err:     errors.New("ERR increment or decrement would overflow"),
+			wantErr: ErrCacheValueOverflow,
+		},
+		{
+			name:    "other",
+			err:     context.DeadlineExceeded,
+			wantErr: context.DeadlineExceeded,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := r.incrErr("key1", tc.err)
+			assert.ErrorIs(t, err, tc.wantErr)
+			if tc.err != nil {
+				// 原始的错误也还在
+				assert.ErrorIs(t, err, tc.err)
+			}
+		})
+	}
+}
+
+func TestBuildinMapCache_IncrBy(t *testing.T) {
+	ctx := context.Background()
+	c := NewBuildinMapCache()
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.IncrBy(ctx, "counter", 1)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	val, err := c.Get(ctx, "counter")
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), val)
+
+	// 已有的过期时间保持不变，过期之后从0开始
+	require.NoError(t, c.Set(ctx, "expiring", 10, time.Millisecond*50))
+	n, err := c.IncrBy(ctx, "expiring", 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), n)
+	ttl, err := c.TTL(ctx, "expiring")
+	require.NoError(t, err)
+	assert.True(t, ttl > 0 && ttl <= time.Millisecond*50)
+	time.Sleep(time.Millisecond * 60)
+	n, err = c.IncrBy(ctx, "expiring", 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+}
@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// BatchCache 可选的批量接口，一次请求要捞很多key的时候用，RedisCache 只需要一次网络往返，本地缓存只加一次锁
+type BatchCache interface {
+	Cache
+	// GetMulti 只返回命中的key，没命中的不在结果里，也不算错误
+	GetMulti(ctx context.Context, keys []string) (map[string]any, error)
+	SetMulti(ctx context.Context, entries map[string]any, expiration time.Duration) error
+	DeleteMulti(ctx context.Context, keys ...string) error
+}
+
+// BatchLoadFunc 批量回源，只返回查到的key，没查到的不放在结果里
+type BatchLoadFunc func(ctx context.Context, keys []string) (map[string]any, error)
+
+// GetMulti c 实现了 BatchCache 就用批量接口，没实现就一个一个 Get
+func GetMulti(ctx context.Context, c Cache, keys []string) (map[string]any, error) {
+	if bc, ok := c.(BatchCache); ok {
+		return bc.GetMulti(ctx, keys)
+	}
+	res := make(map[string]any, len(keys))
+	for _, key := range keys {
+		val, err := c.Get(ctx, key)
+		if err == nil {
+			res[key] = val
+			continue
+		}
+		if !IsKeyNotFound(err) {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// SetMulti c 实现了 BatchCache 就用批量接口，没实现就一个一个 Set，遇到错误就返回
+func SetMulti(ctx context.Context, c Cache, entries map[string]any, expiration time.Duration) error {
+	if bc, ok := c.(BatchCache); ok {
+		return bc.SetMulti(ctx, entries, expiration)
+	}
+	for key, val := range entries {
+		if err := c.Set(ctx, key, val, expiration); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteMulti c 实现了 BatchCache 就用批量接口，没实现就一个一个 Delete，遇到错误就返回
+func DeleteMulti(ctx context.Context, c Cache, keys ...string) error {
+	if bc, ok := c.(BatchCache); ok {
+		return bc.DeleteMulti(ctx, keys...)
+	}
+	for _, key := range keys {
+		if err := c.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBatchCache(t *testing.T) {
+	mr := miniredis.RunT(t)
+	testCases := []struct {
+		name  string
+		cache func() BatchCache
+	}{
+		{
+			name: "buildin map",
+			cache: func() BatchCache {
+				return NewBuildinMapCache()
+			},
+		},
+		{
+			name: "local",
+			cache: func() BatchCache {
+				return NewLocalCache(nil)
+			},
+		},
+		{
+			name: "sharded",
+			cache: func() BatchCache {
+				return newTestShardedCache(4)
+			},
+		},
+		{
+			name: "redis",
+			cache: func() BatchCache {
+				return NewRedisCache(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			c := tc.cache()
+			require.NoError(t, c.SetMulti(ctx, map[string]any{
+				"key1": "val1",
+				"key2": "val2",
+				"key3": "val3",
+			}, time.Minute))
+			res, err := c.GetMulti(ctx, []string{"key1", "key3", "key4"})
+			require.NoError(t, err)
+			assert.Equal(t, map[string]any{"key1": "val1", "key3": "val3"}, res)
+
+			require.NoError(t, c.DeleteMulti(ctx, "key1", "key2", "key4"))
+			res, err = c.GetMulti(ctx, []string{"key1", "key2", "key3"})
+			require.NoError(t, err)
+			assert.Equal(t, map[string]any{"key3": "val3"}, res)
+
+			stats := c.(StatsCache).Stats()
+			assert.Equal(t, uint64(3), stats.Hits)
+			assert.Equal(t, uint64(3), stats.Misses)
+		})
+	}
+}
+
+func TestRedisCache_BatchRoundTrips(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+	c := NewRedisCache(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	entries := make(map[string]any, 100)
+	keys := make([]string, 0, 100)
+	for i := 0; i < 100; i++ {
+		key := "key" + strconv.Itoa(i)
+		entries[key] = key
+		keys = append(keys, key)
+	}
+	require.NoError(t, c.SetMulti(ctx, entries, time.Minute))
+	before := mr.CommandCount()
+	res, err := c.GetMulti(ctx, keys)
+	require.NoError(t, err)
+	assert.Equal(t, entries, res)
+	assert.Equal(t, 1, mr.CommandCount()-before)
+	assert.Equal(t, time.Minute, mr.TTL("key0"))
+}
+
+func TestReadThroughCache_GetMulti(t *testing.T) {
+	ctx := context.Background()
+	db := map[string]any{"key1": "val1", "key2": "val2", "key3": "val3"}
+	var (
+		mutex  sync.Mutex
+		loaded [][]string
+	)
+	batchLoad := func(ctx context.Context, keys []string) (map[string]any, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		sorted := append([]string{}, keys...)
+		sort.Strings(sorted)
+		loaded = append(loaded, sorted)
+		res := make(map[string]any)
+		for _, key := range keys {
+			if val, ok := db[key]; ok {
+				res[key] = val
+			}
+		}
+		return res, nil
+	}
+	local := NewBuildinMapCache()
+	defer local.Close()
+	require.NoError(t, local.Set(ctx, "key1", "cached1", time.Minute))
+	c := NewReadThroughCache(local, time.Minute, nil, WithBatchLoadFunc(batchLoad),
+		WithNegativeCache(time.Minute, nil))
+
+	res, err := c.GetMulti(ctx, []string{"key1", "key2", "key3", "key4"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"key1": "cached1", "key2": "val2", "key3": "val3"}, res)
+	// 只加载没命中的，而且只加载一次
+	assert.Equal(t, [][]string{{"key2", "key3", "key4"}}, loaded)
+
+	// 加载到的写回了缓存，不存在的 key4 有负缓存，不会再回源
+	res, err = c.GetMulti(ctx, []string{"key2", "key4"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"key2": "val2"}, res)
+	assert.Len(t, loaded, 1)
+
+	stats := c.Stats()
+	assert.Equal(t, uint64(3), stats.Hits)
+	assert.Equal(t, uint64(3), stats.Misses)
+	assert.Equal(t, uint64(1), stats.Loads)
+}
+
+func TestReadThroughCache_GetMultiFallback(t *testing.T) {
+	ctx := context.Background()
+	loadErr := errors.New("db down")
+	c := NewReadThroughCache(NewLocalCache(nil), time.Minute, func(ctx context.Context, key string) (any, error) {
+		switch key {
+		case "bad":
+			return nil, loadErr
+		case "missing":
+			return nil, ErrDataNotFound
+		}
+		return key, nil
+	})
+	// 没有 BatchLoadFunc 的时候一个一个加载，不存在的跳过
+	res, err := c.GetMulti(ctx, []string{"key1", "missing", "key2"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"key1": "key1", "key2": "key2"}, res)
+
+	_, err = c.GetMulti(ctx, []string{"key1", "bad"})
+	assert.ErrorIs(t, err, loadErr)
+}
+
+func TestBloomFilterCache_GetMulti(t *testing.T) {
+	ctx := context.Background()
+	var loaded []string
+	c := NewBloomFilterCache(NewLocalCache(nil), time.Minute, nil, func(ctx context.Context, key string) bool {
+		return key != "attack"
+	}, WithBatchLoadFunc(func(ctx context.Context, keys []string) (map[string]any, error) {
+		loaded = append(loaded, keys...)
+		return map[string]any{"key1": "val1"}, nil
+	}))
+	res, err := c.GetMulti(ctx, []string{"key1", "attack"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"key1": "val1"}, res)
+	assert.Equal(t, []string{"key1"}, loaded)
+}
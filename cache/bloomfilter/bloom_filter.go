@@ -0,0 +1,115 @@
+package bloomfilter
+
+import (
+	"context"
+	"errors"
+	"github.com/xuhaidong1/go-generic-tools/cache"
+	"math"
+)
+
+// Filter 布隆过滤器。MightContain 返回 false 说明 key 一定不存在，返回 true 只是可能存在
+type Filter interface {
+	Add(ctx context.Context, key string) error
+	// AddMulti 批量添加，预热的时候用，redis 实现一次往返就能加完一批
+	AddMulti(ctx context.Context, keys ...string) error
+	MightContain(ctx context.Context, key string) (bool, error)
+}
+
+var ErrInvalidParam = errors.New("bloomfilter:预期数量必须大于0，误判率必须在(0,1)之间")
+
+// Optimal 根据预期元素个数 n 和误判率 p 计算位数组长度 m 和哈希函数个数 k
+// m = -n*ln(p)/(ln2)^2，k = m/n*ln2
+func Optimal(n uint64, p float64) (m uint64, k uint64, err error) {
+	if n == 0 || p <= 0 || p >= 1 {
+		return 0, 0, ErrInvalidParam
+	}
+	m = uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	k = uint64(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return m, k, nil
+}
+
+// locations 用 double hashing 算出 key 的 k 个位：h1 + i*h2
+func locations(key string, m, k uint64) []uint64 {
+	h1, h2 := hash(key)
+	res := make([]uint64, k)
+	for i := uint64(0); i < k; i++ {
+		res[i] = (h1 + i*h2) % m
+	}
+	return res
+}
+
+func hash(key string) (uint64, uint64) {
+	const (
+		offset64 = 14695981039346656037
+		prime64  = 1099511628211
+	)
+	var h uint64 = offset64
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= prime64
+	}
+	h2 := (h ^ (h >> 31)) * 0xbf58476d1ce4e5b9
+	return h, h2 | 1
+}
+
+// AsBloomFilter 适配成 BloomFilterCache 用的 cache.BloomFilter。
+// 过滤器出错（比如 redis 超时）的时候当作可能存在，宁可多查一次库也不能把存在的数据拦掉
+func AsBloomFilter(f Filter) cache.BloomFilter {
+	return func(ctx context.Context, key string) bool {
+		ok, err := f.MightContain(ctx, key)
+		if err != nil {
+			return true
+		}
+		return ok
+	}
+}
+
+// KeyIterator 依次返回要预热的key，ok 为 false 表示遍历完了
+type KeyIterator func(ctx context.Context) (key string, ok bool, err error)
+
+// SliceIterator 把切片包装成 KeyIterator
+func SliceIterator(keys []string) KeyIterator {
+	i := 0
+	return func(ctx context.Context) (string, bool, error) {
+		if i >= len(keys) {
+			return "", false, nil
+		}
+		i++
+		return keys[i-1], true, nil
+	}
+}
+
+// Preload 从迭代器里读出所有 key 批量加入过滤器，比如启动的时候从数据库里捞出所有 id，
+// 返回加进去的 key 的个数
+func Preload(ctx context.Context, f Filter, keys KeyIterator, batchSize int) (int, error) {
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+	cnt := 0
+	batch := make([]string, 0, batchSize)
+	for {
+		if ctx.Err() != nil {
+			return cnt, ctx.Err()
+		}
+		key, ok, err := keys(ctx)
+		if err != nil {
+			return cnt, err
+		}
+		if ok {
+			batch = append(batch, key)
+		}
+		if len(batch) == batchSize || (!ok && len(batch) > 0) {
+			if err = f.AddMulti(ctx, batch...); err != nil {
+				return cnt, err
+			}
+			cnt += len(batch)
+			batch = batch[:0]
+		}
+		if !ok {
+			return cnt, nil
+		}
+	}
+}
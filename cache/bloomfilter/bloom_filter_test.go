@@ -0,0 +1,127 @@
+package bloomfilter
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xuhaidong1/go-generic-tools/cache"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestOptimal(t *testing.T) {
+	testCases := []struct {
+		name    string
+		n       uint64
+		p       float64
+		wantM   uint64
+		wantK   uint64
+		wantErr error
+	}{
+		{
+			name:  "1% false positive",
+			n:     1000,
+			p:     0.01,
+			wantM: 9586,
+			wantK: 7,
+		},
+		{
+			name:    "invalid rate",
+			n:       1000,
+			p:       1,
+			wantErr: ErrInvalidParam,
+		},
+		{
+			name:    "zero items",
+			p:       0.01,
+			wantErr: ErrInvalidParam,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m, k, err := Optimal(tc.n, tc.p)
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.wantM, m)
+			assert.Equal(t, tc.wantK, k)
+		})
+	}
+}
+
+func TestFilter(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	testCases := []struct {
+		name   string
+		filter func(t *testing.T) Filter
+	}{
+		{
+			name: "memory",
+			filter: func(t *testing.T) Filter {
+				f, err := NewMemoryBloomFilter(1000, 0.01)
+				require.NoError(t, err)
+				return f
+			},
+		},
+		{
+			name: "redis",
+			filter: func(t *testing.T) Filter {
+				f, err := NewRedisBloomFilter(client, "bf:user", 1000, 0.01)
+				require.NoError(t, err)
+				return f
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			f := tc.filter(t)
+			keys := make([]string, 0, 1000)
+			for i := 0; i < 1000; i++ {
+				keys = append(keys, "user"+strconv.Itoa(i))
+			}
+			cnt, err := Preload(ctx, f, SliceIterator(keys), 300)
+			require.NoError(t, err)
+			assert.Equal(t, 1000, cnt)
+			require.NoError(t, f.Add(ctx, "extra"))
+			// 加进去的一定能找到
+			for _, key := range append(keys, "extra") {
+				ok, err := f.MightContain(ctx, key)
+				require.NoError(t, err)
+				require.True(t, ok, key)
+			}
+			// 没加的误判率应该在 1% 附近
+			fp := 0
+			for i := 0; i < 10000; i++ {
+				ok, err := f.MightContain(ctx, "absent"+strconv.Itoa(i))
+				require.NoError(t, err)
+				if ok {
+					fp++
+				}
+			}
+			assert.Less(t, fp, 300)
+		})
+	}
+}
+
+func TestAsBloomFilter(t *testing.T) {
+	f, err := NewMemoryBloomFilter(100, 0.01)
+	require.NoError(t, err)
+	ctx := context.Background()
+	require.NoError(t, f.Add(ctx, "key1"))
+	loaded := 0
+	c := cache.NewBloomFilterCache(cache.NewBuildinMapCache(), time.Minute,
+		func(ctx context.Context, key string) (any, error) {
+			loaded++
+			return "val", nil
+		}, AsBloomFilter(f))
+	val, err := c.Get(ctx, "key1")
+	require.NoError(t, err)
+	assert.Equal(t, "val", val)
+	// 过滤器拦住了，不会回源
+	_, err = c.Get(ctx, "key2")
+	assert.Error(t, err)
+	assert.Equal(t, 1, loaded)
+}
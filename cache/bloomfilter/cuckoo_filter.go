@@ -0,0 +1,252 @@
+package bloomfilter
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"sync"
+)
+
+// DeletableFilter 支持删除的过滤器，数据库删了记录之后把 key 也从过滤器里删掉，防穿透的效果就不会越来越差
+type DeletableFilter interface {
+	Filter
+	Delete(ctx context.Context, key string) error
+}
+
+const (
+	bucketSize = 4
+	maxKicks   = 500
+	//cuckooLoadFactor 4 个槽位的桶一般能装到 95% 左右
+	cuckooLoadFactor = 0.95
+)
+
+var (
+	ErrFilterFull     = errors.New("bloomfilter:cuckoo filter 已经满了")
+	ErrInvalidPayload = errors.New("bloomfilter:无法解析 cuckoo filter 的快照")
+	cuckooMagic       = [4]byte{'C', 'K', 'F', '1'}
+)
+
+// CuckooFilter 布谷鸟过滤器，和布隆过滤器一样可能误判存在，但是支持删除。
+// 每个 key 存一个 16 位指纹，放在两个候选桶之一：i2 = i1 ^ hash(指纹)，所以只靠指纹就能算出另一个桶，
+// 两个桶都满了就随机踢走一个指纹让它去它的另一个桶。每个桶4个槽位，误判率大约是 8/65536。
+// 只能删除确实加过的 key，删除没加过的 key 可能会误删别人的指纹
+type CuckooFilter struct {
+	mutex   sync.RWMutex
+	buckets [][bucketSize]uint16
+	mask    uint64
+	count   uint64
+	//victim 踢了 maxKicks 次还没地方放的指纹先暂存在这里，这时候过滤器就算满了
+	victim cuckooVictim
+}
+
+type cuckooVictim struct {
+	used  bool
+	index uint64
+	fp    uint16
+}
+
+// NewCuckooFilter expectedItems 预期元素个数，桶的个数会向上取整到2的幂
+func NewCuckooFilter(expectedItems uint64) (*CuckooFilter, error) {
+	if expectedItems == 0 {
+		return nil, ErrInvalidParam
+	}
+	need := uint64(float64(expectedItems)/(bucketSize*cuckooLoadFactor)) + 1
+	n := uint64(1)
+	for n < need {
+		n <<= 1
+	}
+	return &CuckooFilter{
+		buckets: make([][bucketSize]uint16, n),
+		mask:    n - 1,
+	}, nil
+}
+
+func (f *CuckooFilter) Add(ctx context.Context, key string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.add(key)
+}
+
+func (f *CuckooFilter) AddMulti(ctx context.Context, keys ...string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, key := range keys {
+		if err := f.add(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *CuckooFilter) add(key string) error {
+	if f.victim.used {
+		return ErrFilterFull
+	}
+	i1, fp := f.indexAndFingerprint(key)
+	i2 := f.altIndex(i1, fp)
+	if f.insert(i1, fp) || f.insert(i2, fp) {
+		f.count++
+		return nil
+	}
+	i := i1
+	if rand.Intn(2) == 1 {
+		i = i2
+	}
+	for n := 0; n < maxKicks; n++ {
+		slot := rand.Intn(bucketSize)
+		fp, f.buckets[i][slot] = f.buckets[i][slot], fp
+		i = f.altIndex(i, fp)
+		if f.insert(i, fp) {
+			f.count++
+			return nil
+		}
+	}
+	//新key已经放进去了，最后被踢出来的那个先暂存，不能丢
+	f.victim = cuckooVictim{used: true, index: i, fp: fp}
+	f.count++
+	return nil
+}
+
+func (f *CuckooFilter) MightContain(ctx context.Context, key string) (bool, error) {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	i1, fp := f.indexAndFingerprint(key)
+	i2 := f.altIndex(i1, fp)
+	if f.victim.used && f.victim.fp == fp && (f.victim.index == i1 || f.victim.index == i2) {
+		return true, nil
+	}
+	return f.find(i1, fp) >= 0 || f.find(i2, fp) >= 0, nil
+}
+
+// Delete 删除一个 key，key 不存在的时候什么都不做
+func (f *CuckooFilter) Delete(ctx context.Context, key string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	i1, fp := f.indexAndFingerprint(key)
+	i2 := f.altIndex(i1, fp)
+	if f.victim.used && f.victim.fp == fp && (f.victim.index == i1 || f.victim.index == i2) {
+		f.victim = cuckooVictim{}
+		f.count--
+		return nil
+	}
+	for _, i := range []uint64{i1, i2} {
+		if slot := f.find(i, fp); slot >= 0 {
+			f.buckets[i][slot] = 0
+			f.count--
+			f.reinsertVictim()
+			return nil
+		}
+	}
+	return nil
+}
+
+// Count 当前元素个数
+func (f *CuckooFilter) Count() uint64 {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	return f.count
+}
+
+// reinsertVictim 删掉之后腾出了位置，试着把暂存的指纹放回去
+func (f *CuckooFilter) reinsertVictim() {
+	if !f.victim.used {
+		return
+	}
+	v := f.victim
+	if f.insert(v.index, v.fp) || f.insert(f.altIndex(v.index, v.fp), v.fp) {
+		f.victim = cuckooVictim{}
+	}
+}
+
+func (f *CuckooFilter) insert(i uint64, fp uint16) bool {
+	for slot, v := range f.buckets[i] {
+		if v == 0 {
+			f.buckets[i][slot] = fp
+			return true
+		}
+	}
+	return false
+}
+
+func (f *CuckooFilter) find(i uint64, fp uint16) int {
+	for slot, v := range f.buckets[i] {
+		if v == fp {
+			return slot
+		}
+	}
+	return -1
+}
+
+// indexAndFingerprint 指纹取哈希的高16位，0表示空槽，所以指纹不能是0
+func (f *CuckooFilter) indexAndFingerprint(key string) (uint64, uint16) {
+	h, _ := hash(key)
+	fp := uint16(h >> 48)
+	if fp == 0 {
+		fp = 1
+	}
+	return h & f.mask, fp
+}
+
+func (f *CuckooFilter) altIndex(i uint64, fp uint16) uint64 {
+	//指纹的哈希要打散，不然相近的指纹会挤在相近的桶里
+	h := uint64(fp) * 0x5bd1e995
+	return (i ^ h) & f.mask
+}
+
+// MarshalBinary 快照，格式：魔数 | 桶个数 | 元素个数 | victim | 所有桶，全部小端
+func (f *CuckooFilter) MarshalBinary() ([]byte, error) {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	buf := bytes.NewBuffer(make([]byte, 0, 4+8+8+11+len(f.buckets)*bucketSize*2))
+	buf.Write(cuckooMagic[:])
+	var used uint8
+	if f.victim.used {
+		used = 1
+	}
+	header := []any{uint64(len(f.buckets)), f.count, used, f.victim.index, f.victim.fp}
+	for _, v := range header {
+		if err := binary.Write(buf, binary.LittleEndian, v); err != nil {
+			return nil, err
+		}
+	}
+	if err := binary.Write(buf, binary.LittleEndian, f.buckets); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary 从快照恢复，会覆盖掉当前的所有数据
+func (f *CuckooFilter) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	var magic [4]byte
+	if err := binary.Read(r, binary.LittleEndian, &magic); err != nil || magic != cuckooMagic {
+		return ErrInvalidPayload
+	}
+	var (
+		n, count, index uint64
+		used            uint8
+		fp              uint16
+	)
+	for _, v := range []any{&n, &count, &used, &index, &fp} {
+		if err := binary.Read(r, binary.LittleEndian, v); err != nil {
+			return ErrInvalidPayload
+		}
+	}
+	//桶个数必须是2的幂，而且剩下的数据要刚好够
+	if n == 0 || n&(n-1) != 0 || uint64(r.Len()) != n*bucketSize*2 {
+		return ErrInvalidPayload
+	}
+	buckets := make([][bucketSize]uint16, n)
+	if err := binary.Read(r, binary.LittleEndian, buckets); err != nil {
+		return ErrInvalidPayload
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.buckets = buckets
+	f.mask = n - 1
+	f.count = count
+	f.victim = cuckooVictim{used: used == 1, index: index, fp: fp}
+	return nil
+}
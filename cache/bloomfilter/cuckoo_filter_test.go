@@ -0,0 +1,105 @@
+package bloomfilter
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"testing"
+)
+
+func TestCuckooFilter_AddDelete(t *testing.T) {
+	ctx := context.Background()
+	f, err := NewCuckooFilter(1000)
+	require.NoError(t, err)
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, f.Add(ctx, "user"+strconv.Itoa(i)))
+	}
+	assert.Equal(t, uint64(1000), f.Count())
+	for i := 0; i < 1000; i++ {
+		ok, err := f.MightContain(ctx, "user"+strconv.Itoa(i))
+		require.NoError(t, err)
+		require.True(t, ok)
+	}
+	// 删掉一半，剩下的还在，删掉的基本都不在了
+	for i := 0; i < 500; i++ {
+		require.NoError(t, f.Delete(ctx, "user"+strconv.Itoa(i)))
+	}
+	assert.Equal(t, uint64(500), f.Count())
+	fp := 0
+	for i := 0; i < 1000; i++ {
+		ok, err := f.MightContain(ctx, "user"+strconv.Itoa(i))
+		require.NoError(t, err)
+		if i >= 500 {
+			require.True(t, ok)
+		} else if ok {
+			fp++
+		}
+	}
+	assert.Less(t, fp, 10)
+}
+
+func TestCuckooFilter_Full(t *testing.T) {
+	ctx := context.Background()
+	f, err := NewCuckooFilter(8)
+	require.NoError(t, err)
+	var added []string
+	for i := 0; i < 100; i++ {
+		key := "key" + strconv.Itoa(i)
+		if err = f.Add(ctx, key); err != nil {
+			break
+		}
+		added = append(added, key)
+	}
+	assert.Equal(t, ErrFilterFull, err)
+	// 满了之前加进去的一个都不能丢
+	for _, key := range added {
+		ok, err := f.MightContain(ctx, key)
+		require.NoError(t, err)
+		require.True(t, ok, key)
+	}
+	// 删掉之后腾出了位置，暂存的指纹放回去了，又能加了
+	for _, key := range added {
+		require.NoError(t, f.Delete(ctx, key))
+	}
+	assert.NoError(t, f.Add(ctx, "new"))
+}
+
+func TestCuckooFilter_Marshal(t *testing.T) {
+	ctx := context.Background()
+	f, err := NewCuckooFilter(100)
+	require.NoError(t, err)
+	require.NoError(t, f.AddMulti(ctx, "key1", "key2", "key3"))
+	data, err := f.MarshalBinary()
+	require.NoError(t, err)
+
+	restored, err := NewCuckooFilter(1)
+	require.NoError(t, err)
+	require.NoError(t, restored.UnmarshalBinary(data))
+	assert.Equal(t, uint64(3), restored.Count())
+	for _, key := range []string{"key1", "key2", "key3"} {
+		ok, err := restored.MightContain(ctx, key)
+		require.NoError(t, err)
+		assert.True(t, ok)
+	}
+	// 恢复出来的可以继续删
+	require.NoError(t, restored.Delete(ctx, "key2"))
+	ok, err := restored.MightContain(ctx, "key2")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.Equal(t, ErrInvalidPayload, restored.UnmarshalBinary([]byte("bad")))
+	assert.Equal(t, ErrInvalidPayload, restored.UnmarshalBinary(data[:len(data)-1]))
+}
+
+func TestCuckooFilter_AsBloomFilter(t *testing.T) {
+	ctx := context.Background()
+	f, err := NewCuckooFilter(100)
+	require.NoError(t, err)
+	var df DeletableFilter = f
+	bf := AsBloomFilter(df)
+	require.NoError(t, df.Add(ctx, "key1"))
+	assert.True(t, bf(ctx, "key1"))
+	require.NoError(t, df.Delete(ctx, "key1"))
+	assert.False(t, bf(ctx, "key1"))
+}
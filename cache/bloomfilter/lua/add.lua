@@ -0,0 +1,5 @@
+--- 把 ARGV 里的每个 offset 都置为1，一个 key 的 k 个位在同一个脚本里设置，保证原子性
+for i = 1, #ARGV do
+    redis.call("setbit", KEYS[1], ARGV[i], 1)
+end
+return 1
@@ -0,0 +1,14 @@
+--- ARGV[1] 是 k，后面每 k 个 offset 是一个 key 的所有位，返回每个 key 是否可能存在
+local k = tonumber(ARGV[1])
+local res = {}
+for i = 2, #ARGV, k do
+    local exist = 1
+    for j = i, i + k - 1 do
+        if redis.call("getbit", KEYS[1], ARGV[j]) == 0 then
+            exist = 0
+            break
+        end
+    end
+    table.insert(res, exist)
+end
+return res
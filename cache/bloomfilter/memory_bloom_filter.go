@@ -0,0 +1,59 @@
+package bloomfilter
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryBloomFilter 基于本地位数组的布隆过滤器，适合单实例或者每个实例各自预热的场景
+type MemoryBloomFilter struct {
+	mutex sync.RWMutex
+	bits  []uint64
+	m     uint64
+	k     uint64
+}
+
+// NewMemoryBloomFilter expectedItems 预期元素个数，fpRate 误判率
+func NewMemoryBloomFilter(expectedItems uint64, fpRate float64) (*MemoryBloomFilter, error) {
+	m, k, err := Optimal(expectedItems, fpRate)
+	if err != nil {
+		return nil, err
+	}
+	return &MemoryBloomFilter{
+		bits: make([]uint64, (m+63)/64),
+		m:    m,
+		k:    k,
+	}, nil
+}
+
+func (f *MemoryBloomFilter) Add(ctx context.Context, key string) error {
+	return f.AddMulti(ctx, key)
+}
+
+func (f *MemoryBloomFilter) AddMulti(ctx context.Context, keys ...string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, key := range keys {
+		for _, loc := range locations(key, f.m, f.k) {
+			f.bits[loc/64] |= 1 << (loc % 64)
+		}
+	}
+	return nil
+}
+
+func (f *MemoryBloomFilter) MightContain(ctx context.Context, key string) (bool, error) {
+	locs := locations(key, f.m, f.k)
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	for _, loc := range locs {
+		if f.bits[loc/64]&(1<<(loc%64)) == 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Cap 位数组长度和哈希函数个数
+func (f *MemoryBloomFilter) Cap() (m uint64, k uint64) {
+	return f.m, f.k
+}
@@ -0,0 +1,95 @@
+package bloomfilter
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	//go:embed lua/add.lua
+	luaAdd string
+	//go:embed lua/contains.lua
+	luaContains string
+)
+
+// redis 的 bitmap 最多 2^32 位
+const redisMaxBits = 1 << 32
+
+var ErrTooManyBits = errors.New("bloomfilter:位数组超过了 redis bitmap 的上限，调小预期数量或者调大误判率")
+
+// RedisBloomFilter 基于 redis bitmap 的布隆过滤器，多个实例共享同一份数据。
+// 一个 key 的 k 个位在同一个 lua 脚本里 SETBIT/GETBIT，保证原子性
+type RedisBloomFilter struct {
+	client redis.Cmdable
+	key    string
+	m      uint64
+	k      uint64
+}
+
+// NewRedisBloomFilter key 是 bitmap 在 redis 里的 key，expectedItems 预期元素个数，fpRate 误判率。
+// 同一个 key 的所有实例要用相同的参数，不然算出来的位对不上
+func NewRedisBloomFilter(client redis.Cmdable, key string, expectedItems uint64, fpRate float64) (*RedisBloomFilter, error) {
+	m, k, err := Optimal(expectedItems, fpRate)
+	if err != nil {
+		return nil, err
+	}
+	if m > redisMaxBits {
+		return nil, ErrTooManyBits
+	}
+	return &RedisBloomFilter{
+		client: client,
+		key:    key,
+		m:      m,
+		k:      k,
+	}, nil
+}
+
+func (f *RedisBloomFilter) Add(ctx context.Context, key string) error {
+	return f.AddMulti(ctx, key)
+}
+
+func (f *RedisBloomFilter) AddMulti(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	args := make([]any, 0, len(keys)*int(f.k))
+	for _, key := range keys {
+		for _, loc := range locations(key, f.m, f.k) {
+			args = append(args, loc)
+		}
+	}
+	return f.client.Eval(ctx, luaAdd, []string{f.key}, args...).Err()
+}
+
+func (f *RedisBloomFilter) MightContain(ctx context.Context, key string) (bool, error) {
+	res, err := f.MightContainMulti(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	return res[0], nil
+}
+
+// MightContainMulti 批量判断，结果和 keys 一一对应
+func (f *RedisBloomFilter) MightContainMulti(ctx context.Context, keys ...string) ([]bool, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	args := make([]any, 0, len(keys)*int(f.k)+1)
+	args = append(args, f.k)
+	for _, key := range keys {
+		for _, loc := range locations(key, f.m, f.k) {
+			args = append(args, loc)
+		}
+	}
+	vals, err := f.client.Eval(ctx, luaContains, []string{f.key}, args...).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+	res := make([]bool, len(vals))
+	for i, v := range vals {
+		res[i] = v == 1
+	}
+	return res, nil
+}
@@ -2,7 +2,10 @@ package cache
 
 import (
 	"context"
-	"errors"
+	"github.com/xuhaidong1/go-generic-tools/cache/errs"
+	"github.com/xuhaidong1/go-generic-tools/container/queue"
+	"io"
+	"math"
 	"sync"
 	"time"
 )
@@ -14,17 +17,75 @@ type BulidinMapCache struct {
 	closed        bool
 	onEvicted     func(key string, val any)
 	cycleInterval time.Duration
+	//maxEntries 为0表示不限制key的数量
+	maxEntries int
+	policy     EvictionPolicy
+	//policyFactory 没有指定 policy 的时候用它创建一个，ShardedCache 每个分片各自创建
+	policyFactory func() EvictionPolicy
+	//maxCost 为0表示不限制总开销，cost 是当前所有key的开销之和
+	maxCost      int64
+	maxEntryCost int64
+	cost         int64
+	weigher      func(key string, val any) int64
+	recorder     StatsRecorder
+	//snapshotCodec 快照里值的序列化方式，snapshotFile 不为nil的时候会定时写快照文件
+	snapshotCodec    Codec
+	snapshotPath     string
+	snapshotInterval time.Duration
+	snapshotFile     *snapshotFile
+	//wheel 不为nil的时候用时间轮精确过期，不再轮询
+	wheelTick time.Duration
+	wheelSize int
+	wheel     *queue.TimingWheel[string]
+	tags      tagIndex
+	//version 所有key共用的写入计数，删了再写版本号也不会重复
+	version uint64
 }
 
 func NewBuildinMapCache(opts ...CacheOption) *BulidinMapCache {
+	res := newBuildinMapCache(opts...)
+	if res.snapshotPath != "" {
+		res.snapshotFile = newSnapshotFile(res.snapshotPath, res.snapshotInterval, res)
+		res.snapshotFile.start()
+	}
+	return res
+}
+
+// newBuildinMapCache 不处理快照文件，ShardedCache 的分片用它，快照文件由 ShardedCache 统一写
+func newBuildinMapCache(opts ...CacheOption) *BulidinMapCache {
 	res := &BulidinMapCache{
 		data:          make(map[string]*item),
+		close:         make(chan struct{}),
 		cycleInterval: time.Second * 10,
+		recorder:      NewStatsRecorder(),
+		snapshotCodec: GobAnyCodec{},
 	}
 	for _, opt := range opts {
 		opt(res)
 	}
-	res.checkCycle()
+	res.recorder = orNoopRecorder(res.recorder)
+	if res.maxCost > 0 {
+		if res.weigher == nil {
+			res.weigher = func(key string, val any) int64 {
+				return 1
+			}
+		}
+		if res.maxEntryCost <= 0 {
+			res.maxEntryCost = res.maxCost
+		}
+	}
+	if res.policy == nil && res.policyFactory != nil {
+		res.policy = res.policyFactory()
+	}
+	if (res.maxEntries > 0 || res.maxCost > 0) && res.policy == nil {
+		res.policy = LRU()
+	}
+	setPolicyCapacity(res.policy, res.maxEntries)
+	if res.wheelTick > 0 {
+		res.wheel = queue.NewTimingWheel[string](res.wheelTick, res.wheelSize, res.expire)
+	} else {
+		res.checkCycle()
+	}
 	return res
 }
 
@@ -32,28 +93,139 @@ func (c *BulidinMapCache) Set(ctx context.Context, key string, val any, expirati
 	c.lock.Lock()
 	defer c.lock.Unlock()
 	if c.closed {
-		return errors.New("缓存已经被关闭")
+		return ErrCacheClosed
 	}
-	var dl time.Time
-	if expiration > 0 {
-		dl = time.Now().Add(expiration)
+	cost, err := c.weigh(key, val)
+	if err != nil {
+		return err
+	}
+	c.set(key, val, deadlineOf(expiration), cost)
+	return nil
+}
+
+func (c *BulidinMapCache) SetWithTags(ctx context.Context, key string, val any, expiration time.Duration, tags ...string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return ErrCacheClosed
+	}
+	cost, err := c.weigh(key, val)
+	if err != nil {
+		return err
 	}
-	c.data[key] = &item{
+	c.set(key, val, deadlineOf(expiration), cost)
+	//淘汰策略可能拒绝了这个key
+	if _, ok := c.data[key]; ok {
+		c.tags.add(key, tags)
+	}
+	return nil
+}
+
+// InvalidateTags 删掉的key会触发 onEvicted
+func (c *BulidinMapCache) InvalidateTags(ctx context.Context, tags ...string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return ErrCacheClosed
+	}
+	for _, key := range c.tags.keysOf(tags) {
+		c.delete(key, EvictionReasonDeleted)
+	}
+	return nil
+}
+
+// SetMulti 只加一次锁，有一个值超过了单个key的开销上限就全部不写
+func (c *BulidinMapCache) SetMulti(ctx context.Context, entries map[string]any, expiration time.Duration) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return ErrCacheClosed
+	}
+	costs := make(map[string]int64, len(entries))
+	for key, val := range entries {
+		cost, err := c.weigh(key, val)
+		if err != nil {
+			return err
+		}
+		costs[key] = cost
+	}
+	dl := deadlineOf(expiration)
+	for key, val := range entries {
+		c.set(key, val, dl, costs[key])
+	}
+	return nil
+}
+
+func (c *BulidinMapCache) weigh(key string, val any) (int64, error) {
+	if c.weigher == nil {
+		return 0, nil
+	}
+	cost := c.weigher(key, val)
+	if c.maxEntryCost > 0 && cost > c.maxEntryCost {
+		return 0, ErrCacheValueTooBig
+	}
+	return cost, nil
+}
+
+// set dl 是零值表示不过期，调用方需要持有写锁
+func (c *BulidinMapCache) set(key string, val any, dl time.Time, cost int64) {
+	old, exist := c.data[key]
+	if exist {
+		c.cost -= old.cost
+		old.stopTimer()
+	}
+	c.version++
+	itm := &item{
 		Val:      val,
 		Deadline: dl,
+		cost:     cost,
+		version:  c.version,
+	}
+	c.data[key] = itm
+	c.schedule(key, itm)
+	c.cost += cost
+	if c.policy != nil {
+		if exist {
+			c.policy.KeyAccessed(key)
+		} else {
+			c.policy.KeyAdded(key)
+		}
+		c.evict()
+	}
+}
+
+// evict 超过容量或者总开销超了就按淘汰策略删key，淘汰同样会触发 onEvicted，调用方需要持有写锁
+func (c *BulidinMapCache) evict() {
+	for (c.maxEntries > 0 && len(c.data) > c.maxEntries) || (c.maxCost > 0 && c.cost > c.maxCost) {
+		key, ok := c.policy.Evict()
+		if !ok {
+			return
+		}
+		c.delete(key, EvictionReasonCapacity)
 	}
-	return nil
 }
 
 func (c *BulidinMapCache) Get(ctx context.Context, key string) (any, error) {
+	itm, err := c.get(key)
+	if err != nil {
+		return nil, err
+	}
+	return itm.Val, nil
+}
+
+func (c *BulidinMapCache) get(key string) (*item, error) {
 	if c.closed {
 		return nil, ErrCacheClosed
 	}
 	c.lock.RLock()
 	itm, ok := c.data[key]
 	c.lock.RUnlock()
+	if c.policy != nil {
+		c.policy.KeyAccessed(key)
+	}
 	if !ok {
-		return nil, ErrCacheKeyNotExist
+		c.recorder.RecordMisses(1)
+		return nil, errs.NewErrKeyNotFound(key)
 	}
 	// double check 以防别的 goroutine 设置值了
 	now := time.Now()
@@ -65,14 +237,162 @@ func (c *BulidinMapCache) Get(ctx context.Context, key string) (any, error) {
 		}
 		itm, ok = c.data[key]
 		if !ok {
-			return nil, ErrCacheKeyNotExist
+			c.recorder.RecordMisses(1)
+			return nil, errs.NewErrKeyNotFound(key)
 		}
 		if itm.deadlineBefore(now) {
-			c.delete(key)
-			return nil, ErrCacheKeyNotExist
+			c.delete(key, EvictionReasonExpired)
+			c.recorder.RecordMisses(1)
+			return nil, errs.NewErrKeyNotFound(key)
 		}
 	}
-	return itm.Val, nil
+	c.recorder.RecordHits(1)
+	return itm, nil
+}
+
+// GetWithVersion 版本号是全局递增的写入计数，只要写过（哪怕写的是一样的值）版本号就会变
+func (c *BulidinMapCache) GetWithVersion(ctx context.Context, key string) (any, uint64, error) {
+	itm, err := c.get(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	return itm.Val, itm.version, nil
+}
+
+func (c *BulidinMapCache) SetNX(ctx context.Context, key string, val any, expiration time.Duration) (bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return false, ErrCacheClosed
+	}
+	if _, ok := c.live(key); ok {
+		return false, nil
+	}
+	cost, err := c.weigh(key, val)
+	if err != nil {
+		return false, err
+	}
+	c.set(key, val, deadlineOf(expiration), cost)
+	return true, nil
+}
+
+func (c *BulidinMapCache) GetOrSet(ctx context.Context, key string, val any, expiration time.Duration) (any, bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return nil, false, ErrCacheClosed
+	}
+	if itm, ok := c.live(key); ok {
+		c.recorder.RecordHits(1)
+		if c.policy != nil {
+			c.policy.KeyAccessed(key)
+		}
+		return itm.Val, true, nil
+	}
+	c.recorder.RecordMisses(1)
+	cost, err := c.weigh(key, val)
+	if err != nil {
+		return nil, false, err
+	}
+	c.set(key, val, deadlineOf(expiration), cost)
+	return val, false, nil
+}
+
+func (c *BulidinMapCache) CompareAndSwap(ctx context.Context, key string, version uint64, val any, expiration time.Duration) (bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return false, ErrCacheClosed
+	}
+	var cur uint64
+	if itm, ok := c.live(key); ok {
+		cur = itm.version
+	}
+	if cur != version {
+		return false, nil
+	}
+	cost, err := c.weigh(key, val)
+	if err != nil {
+		return false, err
+	}
+	c.set(key, val, deadlineOf(expiration), cost)
+	return true, nil
+}
+
+// IncrBy 结果存成 int64
+func (c *BulidinMapCache) IncrBy(ctx context.Context, key string, delta int64) (int64, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return 0, ErrCacheClosed
+	}
+	var dl time.Time
+	n := delta
+	if itm, ok := c.live(key); ok {
+		cur, ok := toInt64(itm.Val)
+		if !ok {
+			return 0, errs.NewKeyError(key, ErrCacheValueType, nil)
+		}
+		//和 redis 一样，溢出了报错，不回绕
+		if (delta > 0 && cur > math.MaxInt64-delta) || (delta < 0 && cur < math.MinInt64-delta) {
+			return 0, errs.NewKeyError(key, ErrCacheValueOverflow, nil)
+		}
+		n += cur
+		dl = itm.Deadline
+	}
+	cost, err := c.weigh(key, n)
+	if err != nil {
+		return 0, err
+	}
+	c.set(key, n, dl, cost)
+	return n, nil
+}
+
+// DecrBy math.MinInt64 取反还是它自己，直接算溢出
+func (c *BulidinMapCache) DecrBy(ctx context.Context, key string, delta int64) (int64, error) {
+	if delta == math.MinInt64 {
+		return 0, errs.NewKeyError(key, ErrCacheValueOverflow, nil)
+	}
+	return c.IncrBy(ctx, key, -delta)
+}
+
+// live 没过期的key，过期了还没删的顺手删掉，调用方需要持有写锁
+func (c *BulidinMapCache) live(key string) (*item, bool) {
+	itm, ok := c.data[key]
+	if !ok {
+		return nil, false
+	}
+	if itm.deadlineBefore(time.Now()) {
+		c.delete(key, EvictionReasonExpired)
+		return nil, false
+	}
+	return itm, true
+}
+
+// GetMulti 只加一次读锁，过期了还没删的当作没命中，留给轮询删除
+func (c *BulidinMapCache) GetMulti(ctx context.Context, keys []string) (map[string]any, error) {
+	c.lock.RLock()
+	if c.closed {
+		c.lock.RUnlock()
+		return nil, ErrCacheClosed
+	}
+	res := make(map[string]any, len(keys))
+	now := time.Now()
+	for _, key := range keys {
+		itm, ok := c.data[key]
+		if ok && !itm.deadlineBefore(now) {
+			res[key] = itm.Val
+		}
+	}
+	c.lock.RUnlock()
+	if c.policy != nil {
+		for _, key := range keys {
+			c.policy.KeyAccessed(key)
+		}
+	}
+	c.recorder.RecordHits(len(res))
+	c.recorder.RecordMisses(len(keys) - len(res))
+	return res, nil
 }
 
 func (c *BulidinMapCache) OnEvicted(fn func(key string, val any)) {
@@ -93,6 +413,94 @@ func WithCycleInterval(interval time.Duration) CacheOption {
 	}
 }
 
+// WithMaxEntries 限制key的数量，超出之后按淘汰策略淘汰，没有指定策略默认用 LRU
+func WithMaxEntries(n int) CacheOption {
+	return func(b *BulidinMapCache) {
+		b.maxEntries = n
+	}
+}
+
+// WithEvictionPolicy 指定淘汰策略，比如 LRU()、LFU()、FIFO()、Random()、WTinyLFU()
+func WithEvictionPolicy(policy EvictionPolicy) CacheOption {
+	return func(b *BulidinMapCache) {
+		b.policy = policy
+	}
+}
+
+// WithEvictionPolicyFactory 和 WithEvictionPolicy 一样，只是每个缓存调用一次 factory 创建自己的策略，
+// ShardedCache 要用这个，比如 WithEvictionPolicyFactory(LFU)
+func WithEvictionPolicyFactory(factory func() EvictionPolicy) CacheOption {
+	return func(b *BulidinMapCache) {
+		b.policyFactory = factory
+	}
+}
+
+// WithMaxCost 限制所有key的总开销，比如按字节数限制内存，开销由 WithWeigher 计算，
+// 没有指定 weigher 的时候每个key的开销是1
+func WithMaxCost(maxCost int64) CacheOption {
+	return func(b *BulidinMapCache) {
+		b.maxCost = maxCost
+	}
+}
+
+// WithWeigher 计算单个key的开销
+func WithWeigher(weigher func(key string, val any) int64) CacheOption {
+	return func(b *BulidinMapCache) {
+		b.weigher = weigher
+	}
+}
+
+// BytesWeigher 按key和值的字节数估算开销，值只认识 string 和 []byte，其它类型按1算
+func BytesWeigher(key string, val any) int64 {
+	switch v := val.(type) {
+	case string:
+		return int64(len(key) + len(v))
+	case []byte:
+		return int64(len(key) + len(v))
+	default:
+		return int64(len(key)) + 1
+	}
+}
+
+// WithMaxEntryCost 单个key的开销上限，超过的直接拒绝写入，返回 ErrCacheValueTooBig，默认等于 maxCost
+func WithMaxEntryCost(maxEntryCost int64) CacheOption {
+	return func(b *BulidinMapCache) {
+		b.maxEntryCost = maxEntryCost
+	}
+}
+
+// WithStatsRecorder 替换默认的统计埋点，比如接到监控系统
+func WithStatsRecorder(recorder StatsRecorder) CacheOption {
+	return func(b *BulidinMapCache) {
+		b.recorder = recorder
+	}
+}
+
+// WithSnapshotCodec 快照里值的序列化方式，默认 GobAnyCodec，自定义类型记得 gob.Register
+func WithSnapshotCodec(codec Codec) CacheOption {
+	return func(b *BulidinMapCache) {
+		b.snapshotCodec = codec
+	}
+}
+
+// WithSnapshotFile 创建的时候如果 path 存在就先从它恢复，之后每隔 interval 把快照写到 path，Close 的时候再写一次。
+// interval 小于等于0表示只在 Close 的时候写
+func WithSnapshotFile(path string, interval time.Duration) CacheOption {
+	return func(b *BulidinMapCache) {
+		b.snapshotPath = path
+		b.snapshotInterval = interval
+	}
+}
+
+// WithTimingWheel 用分层时间轮处理过期，key 到期之后最多晚一个 tick 删除，不用再全量轮询，适合key很多的场景。
+// ShardedCache 里每个分片有自己的时间轮
+func WithTimingWheel(tick time.Duration, wheelSize int) CacheOption {
+	return func(b *BulidinMapCache) {
+		b.wheelTick = tick
+		b.wheelSize = wheelSize
+	}
+}
+
 func WithOnEvicted(onEvicted func(key string, val any)) CacheOption {
 	return func(b *BulidinMapCache) {
 		b.onEvicted = onEvicted
@@ -109,43 +517,97 @@ func (c *BulidinMapCache) checkCycle() {
 				for key, itm := range c.data {
 					// 设置了过期时间，并且已经过期
 					if itm.deadlineBefore(now) {
-						c.delete(key)
+						c.delete(key, EvictionReasonExpired)
 					}
 				}
 				c.lock.Unlock()
 			case <-c.close:
-				close(c.close)
+				ticker.Stop()
 				return
 			}
 		}
 	}()
 }
 
+// schedule 用时间轮的话给有过期时间的key加一个定时任务，调用方需要持有写锁
+func (c *BulidinMapCache) schedule(key string, itm *item) {
+	if c.wheel == nil || itm.Deadline.IsZero() {
+		return
+	}
+	itm.timer, _ = c.wheel.AddAt(itm.Deadline, key)
+}
+
+// expire 时间轮的到期回调，key 可能已经被覆盖写成了别的过期时间，要再检查一次
+func (c *BulidinMapCache) expire(key string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return
+	}
+	itm, ok := c.data[key]
+	if ok && itm.deadlineBefore(time.Now()) {
+		c.delete(key, EvictionReasonExpired)
+	}
+}
+
 func (c *BulidinMapCache) Delete(ctx context.Context, key string) error {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 	if c.closed {
 		return ErrCacheClosed
 	}
-	c.delete(key)
+	c.delete(key, EvictionReasonDeleted)
+	return nil
+}
+
+// DeleteMulti 只加一次锁
+func (c *BulidinMapCache) DeleteMulti(ctx context.Context, keys ...string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return ErrCacheClosed
+	}
+	for _, key := range keys {
+		c.delete(key, EvictionReasonDeleted)
+	}
 	return nil
 }
 
-func (c *BulidinMapCache) delete(key string) {
+func (c *BulidinMapCache) delete(key string, reason EvictionReason) {
 	//log.Printf("mapCache 中的delete %s\n", key)
 	itm, ok := c.data[key]
 	if ok {
 		delete(c.data, key)
+		itm.stopTimer()
+		c.tags.remove(key)
+		c.recorder.RecordEviction(reason)
+		c.cost -= itm.cost
+		if c.policy != nil {
+			c.policy.KeyRemoved(key)
+		}
 		if c.onEvicted != nil {
 			c.onEvicted(key, itm.Val)
 		}
 	}
 }
 
+// Close 可以重复调用，只有第一次会触发 onEvicted。配置了快照文件的话先写最后一次快照
 func (c *BulidinMapCache) Close() error {
+	var err error
+	if c.snapshotFile != nil {
+		err = c.snapshotFile.stop()
+	}
+	//到期回调要拿锁，不能拿着锁等时间轮退出
+	if c.wheel != nil {
+		c.wheel.Close()
+	}
 	c.lock.Lock()
 	defer c.lock.Unlock()
-	c.close <- struct{}{}
+	if c.closed {
+		return err
+	}
+	//不能在持有锁的时候发信号等轮询goroutine接收，它可能正在等锁
+	close(c.close)
 	c.closed = true
 	if c.onEvicted != nil {
 		for key, itm := range c.data {
@@ -153,9 +615,119 @@ func (c *BulidinMapCache) Close() error {
 		}
 	}
 	c.data = nil
+	c.cost = 0
+	c.tags.reset()
+	return err
+}
+
+// Range 在读锁里拷一份没过期的key和值，fn 在锁外面调用，里面可以读写缓存。
+// 看到的是调用那一刻的快照，fn 返回false停止，缓存关闭了返回 ErrCacheClosed
+func (c *BulidinMapCache) Range(ctx context.Context, fn func(key string, val any) bool) error {
+	entries, err := c.entries()
+	if err != nil {
+		return err
+	}
+	return rangeEntries(ctx, entries, fn)
+}
+
+func (c *BulidinMapCache) Scan(ctx context.Context, pattern string, cursor uint64, count int64) ([]string, uint64, error) {
+	s := newKeyScanner(pattern, cursor)
+	if err := c.scan(s); err != nil {
+		return nil, 0, err
+	}
+	keys, next := s.result(count)
+	return keys, next, nil
+}
+
+// scan 把没过期的key交给 scanner，排序放到锁外面做
+func (c *BulidinMapCache) scan(s *keyScanner) error {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.closed {
+		return ErrCacheClosed
+	}
+	now := time.Now()
+	for key, itm := range c.data {
+		if !itm.deadlineBefore(now) {
+			s.add(key)
+		}
+	}
+	return nil
+}
+
+func (c *BulidinMapCache) Snapshot(w io.Writer) error {
+	entries, err := c.entries()
+	if err != nil {
+		return err
+	}
+	return writeSnapshot(w, c.snapshotCodec, entries)
+}
+
+// entries 在读锁里把没过期的key拷出来，序列化放到锁外面做
+func (c *BulidinMapCache) entries() ([]snapshotEntry, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.closed {
+		return nil, ErrCacheClosed
+	}
+	res := make([]snapshotEntry, 0, len(c.data))
+	now := time.Now()
+	for key, itm := range c.data {
+		if !itm.deadlineBefore(now) {
+			res = append(res, snapshotEntry{key: key, val: itm.Val, deadline: itm.Deadline})
+		}
+	}
+	return res, nil
+}
+
+// Restore 导入的key照样受容量限制，超过单个key开销上限的直接跳过
+func (c *BulidinMapCache) Restore(r io.Reader) error {
+	entries, err := readSnapshot(r, c.snapshotCodec)
+	if err != nil {
+		return err
+	}
+	return c.restore(entries)
+}
+
+func (c *BulidinMapCache) restore(entries []snapshotEntry) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return ErrCacheClosed
+	}
+	for _, e := range entries {
+		cost, err := c.weigh(e.key, e.val)
+		if err != nil {
+			continue
+		}
+		c.set(e.key, e.val, e.deadline, cost)
+	}
 	return nil
 }
 
+// Stats 命中、淘汰的统计和当前key的数量
+func (c *BulidinMapCache) Stats() Stats {
+	res := c.recorder.Snapshot()
+	c.lock.RLock()
+	res.Entries = len(c.data)
+	c.lock.RUnlock()
+	return res
+}
+
+// deadlineOf expiration 小于等于0表示不过期，返回零值
+func deadlineOf(expiration time.Duration) time.Time {
+	if expiration <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(expiration)
+}
+
+func (i *item) stopTimer() {
+	if i.timer != nil {
+		i.timer.Stop()
+	}
+}
+
 func (i *item) deadlineBefore(t time.Time) bool {
 	return !i.Deadline.IsZero() && i.Deadline.Before(t)
 }
@@ -168,7 +740,7 @@ func (c *BulidinMapCache) TTL(ctx context.Context, key string) (time.Duration, e
 	itm, ok := c.data[key]
 	c.lock.RUnlock()
 	if !ok {
-		return 0, ErrCacheKeyNotExist
+		return 0, errs.NewErrKeyNotFound(key)
 	}
 	// double check 以防别的 goroutine 设置值了
 	now := time.Now()
@@ -180,11 +752,11 @@ func (c *BulidinMapCache) TTL(ctx context.Context, key string) (time.Duration, e
 		}
 		itm, ok = c.data[key]
 		if !ok {
-			return 0, ErrCacheKeyNotExist
+			return 0, errs.NewErrKeyNotFound(key)
 		}
 		if itm.deadlineBefore(now) {
-			c.delete(key)
-			return 0, ErrCacheKeyNotExist
+			c.delete(key, EvictionReasonExpired)
+			return 0, errs.NewErrKeyNotFound(key)
 		}
 	}
 	return itm.Deadline.Sub(time.Now()), nil
@@ -202,12 +774,17 @@ func (c *BulidinMapCache) Expire(key string, expiration time.Duration) error {
 	}
 	itm, ok := c.data[key]
 	if !ok {
-		return ErrCacheKeyNotExist
+		return errs.NewErrKeyNotFound(key)
 	}
-	c.data[key] = &item{
+	itm.stopTimer()
+	itm = &item{
 		Val:      itm.Val,
 		Deadline: dl,
+		cost:     itm.cost,
+		version:  itm.version,
 	}
+	c.data[key] = itm
+	c.schedule(key, itm)
 	return nil
 }
 
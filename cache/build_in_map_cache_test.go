@@ -2,8 +2,11 @@ package cache
 
 import (
 	"context"
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"io"
+	"sync"
 	"testing"
 	"time"
 )
@@ -34,16 +37,17 @@ func TestBuildinMapCache_Get(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			val, err := c.Get(context.Background(), tc.key)
-			assert.Equal(t, tc.wantErr, err)
-			if err != nil {
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, err, tc.wantErr)
 				return
 			}
+			require.NoError(t, err)
 			assert.Equal(t, tc.wantVal, val)
 		})
 	}
 	time.Sleep(time.Second * 3)
 	_, err = c.Get(context.Background(), "key1")
-	assert.Equal(t, ErrCacheKeyNotExist, err)
+	assert.ErrorIs(t, err, ErrCacheKeyNotExist)
 }
 
 func TestBuildinMapCache_checkCycle(t *testing.T) {
@@ -53,5 +57,83 @@ func TestBuildinMapCache_checkCycle(t *testing.T) {
 	// 以防万一
 	time.Sleep(time.Second * 3)
 	_, err = c.Get(context.Background(), "key1")
-	assert.Equal(t, ErrCacheKeyNotExist, err)
+	assert.ErrorIs(t, err, ErrCacheKeyNotExist)
+}
+
+func TestTimingWheelExpiration(t *testing.T) {
+	testCases := []struct {
+		name     string
+		newCache func(onEvicted func(key string, val any)) cacheWithStats
+	}{
+		{
+			name: "buildin map",
+			newCache: func(onEvicted func(key string, val any)) cacheWithStats {
+				// 轮询间隔很长，只能靠时间轮过期
+				return NewBuildinMapCache(WithTimingWheel(time.Millisecond*10, 16),
+					WithCycleInterval(time.Hour), WithOnEvicted(onEvicted))
+			},
+		},
+		{
+			name: "local",
+			newCache: func(onEvicted func(key string, val any)) cacheWithStats {
+				return NewLocalCache(onEvicted, LocalCacheWithTimingWheel(time.Millisecond*10, 16))
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				mutex   sync.Mutex
+				evicted []string
+			)
+			c := tc.newCache(func(key string, val any) {
+				mutex.Lock()
+				defer mutex.Unlock()
+				evicted = append(evicted, key)
+			})
+			defer c.(io.Closer).Close()
+			ctx := context.Background()
+			for i := 0; i < 100; i++ {
+				require.NoError(t, c.Set(ctx, fmt.Sprintf("key%d", i), i, time.Millisecond*50))
+			}
+			// 覆盖写延长过期时间，旧的定时任务要取消掉
+			require.NoError(t, c.Set(ctx, "key0", 0, time.Minute))
+			require.NoError(t, c.Delete(ctx, "key1"))
+			assert.Eventually(t, func() bool {
+				return c.Stats().Entries == 1
+			}, time.Second, time.Millisecond*10)
+			st := c.Stats()
+			assert.Equal(t, uint64(98), st.Evictions[EvictionReasonExpired])
+			assert.Equal(t, uint64(0), st.Misses)
+			val, err := c.Get(ctx, "key0")
+			require.NoError(t, err)
+			assert.Equal(t, 0, val)
+			mutex.Lock()
+			assert.Len(t, evicted, 99)
+			mutex.Unlock()
+		})
+	}
+}
+
+func TestBuildinMapCache_MaxCost(t *testing.T) {
+	var evicted []string
+	c := NewBuildinMapCache(WithMaxCost(20), WithMaxEntryCost(10), WithWeigher(BytesWeigher),
+		WithOnEvicted(func(key string, val any) {
+			evicted = append(evicted, key)
+		}))
+	ctx := context.Background()
+	// 开销 2+5=7
+	require.NoError(t, c.Set(ctx, "k1", "aaaaa", time.Minute))
+	require.NoError(t, c.Set(ctx, "k2", "bbbbb", time.Minute))
+	assert.Equal(t, int64(14), c.cost)
+	// 单个值太大直接拒绝
+	err := c.Set(ctx, "k3", "cccccccccc", time.Minute)
+	assert.Equal(t, ErrCacheValueTooBig, err)
+	// 总开销超过20，淘汰最久没访问的 k1
+	require.NoError(t, c.Set(ctx, "k4", "ddddd", time.Minute))
+	assert.Equal(t, []string{"k1"}, evicted)
+	assert.Equal(t, int64(14), c.cost)
+	// 覆盖写只算新值的开销
+	require.NoError(t, c.Set(ctx, "k4", "d", time.Minute))
+	assert.Equal(t, int64(10), c.cost)
 }
@@ -0,0 +1,278 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// Codec 值的序列化方式，ID 会写进头部字节的低4位，取值1到15，换了 Codec 之后旧数据还能按头部识别出来
+type Codec interface {
+	ID() byte
+	Marshal(val any) ([]byte, error)
+	// Unmarshal dst 是指针
+	Unmarshal(data []byte, dst any) error
+}
+
+// Compressor 压缩算法，ID 会写进头部字节的4到6位，取值1到7
+type Compressor interface {
+	ID() byte
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+// DecodingCache 能把值直接解码到指定类型的缓存，TypedCache 遇到它会直接解码到 V
+type DecodingCache interface {
+	GetInto(ctx context.Context, key string, dst any) error
+}
+
+const (
+	JSONCodecID   byte = 1
+	GobCodecID    byte = 2
+	BytesCodecID  byte = 3
+	ProtoCodecID  byte = 4
+	GobAnyCodecID byte = 5
+
+	GzipCompressorID byte = 1
+)
+
+// JSONCodec 解码到 any 的时候结构体会变成 map[string]any，需要具体类型用 GetInto
+type JSONCodec struct{}
+
+func (JSONCodec) ID() byte {
+	return JSONCodecID
+}
+
+func (JSONCodec) Marshal(val any) ([]byte, error) {
+	return json.Marshal(val)
+}
+
+func (JSONCodec) Unmarshal(data []byte, dst any) error {
+	return json.Unmarshal(data, dst)
+}
+
+// GobCodec gob 的数据里没有类型信息，解码需要具体类型，只能用 GetInto
+type GobCodec struct{}
+
+func (GobCodec) ID() byte {
+	return GobCodecID
+}
+
+func (GobCodec) Marshal(val any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(val); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec) Unmarshal(data []byte, dst any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(dst)
+}
+
+// GobAnyCodec 把值当成 interface 编码，数据里带着类型名，解码到 *any 也能拿回原来的类型，
+// 代价是自定义类型要先 gob.Register。本地缓存的快照默认用它
+type GobAnyCodec struct{}
+
+func (GobAnyCodec) ID() byte {
+	return GobAnyCodecID
+}
+
+func (GobAnyCodec) Marshal(val any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&val); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobAnyCodec) Unmarshal(data []byte, dst any) error {
+	var val any
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&val); err != nil {
+		return err
+	}
+	return assign(dst, val)
+}
+
+// BytesCodec 原样存取，只认识 []byte 和 string，解码出来是 []byte
+type BytesCodec struct{}
+
+func (BytesCodec) ID() byte {
+	return BytesCodecID
+}
+
+func (BytesCodec) Marshal(val any) ([]byte, error) {
+	switch v := val.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	default:
+		return nil, fmt.Errorf("BytesCodec 不支持 %T", val)
+	}
+}
+
+func (BytesCodec) Unmarshal(data []byte, dst any) error {
+	switch d := dst.(type) {
+	case *[]byte:
+		*d = data
+	case *string:
+		*d = string(data)
+	case *any:
+		*d = data
+	default:
+		return fmt.Errorf("BytesCodec 不能解码到 %T", dst)
+	}
+	return nil
+}
+
+// ProtoCodec 为了不依赖 protobuf，序列化由用户传进来，比如
+// NewProtoCodec(func(v any) ([]byte, error) { return proto.Marshal(v.(proto.Message)) },
+// func(data []byte, dst any) error { return proto.Unmarshal(data, dst.(proto.Message)) })。
+// 解码需要具体的 message 类型，只能用 GetInto
+type ProtoCodec struct {
+	marshal   func(val any) ([]byte, error)
+	unmarshal func(data []byte, dst any) error
+}
+
+func NewProtoCodec(marshal func(val any) ([]byte, error), unmarshal func(data []byte, dst any) error) *ProtoCodec {
+	return &ProtoCodec{marshal: marshal, unmarshal: unmarshal}
+}
+
+func (p *ProtoCodec) ID() byte {
+	return ProtoCodecID
+}
+
+func (p *ProtoCodec) Marshal(val any) ([]byte, error) {
+	return p.marshal(val)
+}
+
+func (p *ProtoCodec) Unmarshal(data []byte, dst any) error {
+	//Get 的时候传进来的是 *any，交给用户的函数多半会类型断言失败
+	if _, ok := dst.(*any); ok {
+		return errors.New("ProtoCodec 需要具体的 message 类型，请用 GetInto")
+	}
+	return p.unmarshal(data, dst)
+}
+
+type GzipCompressor struct{}
+
+func (GzipCompressor) ID() byte {
+	return GzipCompressorID
+}
+
+func (GzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GzipCompressor) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// valueCodec 编码的时候用 codec，超过 threshold 字节的用 compressor 压缩；
+// 解码的时候按头部字节找对应的 codec 和 compressor，所以换了 codec 只要把旧的放进 codecs 就能读旧数据
+type valueCodec struct {
+	codec       Codec
+	compressor  Compressor
+	threshold   int
+	codecs      map[byte]Codec
+	compressors map[byte]Compressor
+}
+
+func newValueCodec(codec Codec) *valueCodec {
+	res := &valueCodec{
+		codec:       codec,
+		codecs:      make(map[byte]Codec),
+		compressors: map[byte]Compressor{GzipCompressorID: GzipCompressor{}},
+	}
+	res.addCodecs(JSONCodec{}, GobCodec{}, BytesCodec{}, GobAnyCodec{}, codec)
+	return res
+}
+
+func (v *valueCodec) addCodecs(codecs ...Codec) {
+	for _, c := range codecs {
+		v.codecs[c.ID()&0x0f] = c
+	}
+}
+
+func (v *valueCodec) encode(val any) ([]byte, error) {
+	data, err := v.codec.Marshal(val)
+	if err != nil {
+		return nil, err
+	}
+	header := v.codec.ID() & 0x0f
+	if v.compressor != nil && len(data) > v.threshold {
+		compressed, err := v.compressor.Compress(data)
+		if err != nil {
+			return nil, err
+		}
+		//压缩了反而更大就不压了
+		if len(compressed) < len(data) {
+			data = compressed
+			header |= (v.compressor.ID() & 0x07) << 4
+		}
+	}
+	res := make([]byte, 0, len(data)+1)
+	res = append(res, header)
+	return append(res, data...), nil
+}
+
+// decode ok 为false表示头部不认识，一般是加 codec 之前写进去的老数据。返回的错误由调用方包成 ErrCacheCodec
+func (v *valueCodec) decode(data []byte, dst any) (ok bool, err error) {
+	if len(data) == 0 {
+		return false, nil
+	}
+	codec, ok := v.codecs[data[0]&0x0f]
+	if !ok || data[0]&0x80 != 0 {
+		return false, nil
+	}
+	payload := data[1:]
+	if id := (data[0] >> 4) & 0x07; id != 0 {
+		compressor, ok := v.compressors[id]
+		if !ok {
+			return false, nil
+		}
+		if payload, err = compressor.Decompress(payload); err != nil {
+			return true, err
+		}
+	}
+	return true, codec.Unmarshal(payload, dst)
+}
+
+// assign 把 val 赋值给指针 dst 指向的变量，类型不对返回 ErrCacheValueType
+func assign(dst any, val any) error {
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("%w: dst 必须是非nil的指针，实际 %T", ErrCacheValueType, dst)
+	}
+	elem := rv.Elem()
+	v := reflect.ValueOf(val)
+	if !v.IsValid() {
+		elem.SetZero()
+		return nil
+	}
+	if !v.Type().AssignableTo(elem.Type()) {
+		return fmt.Errorf("%w: 期望 %s，实际 %T", ErrCacheValueType, elem.Type(), val)
+	}
+	elem.Set(v)
+	return nil
+}
@@ -0,0 +1,145 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+type codecUser struct {
+	Name string
+	Age  int
+}
+
+func TestRedisCache_Codec(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+	testCases := []struct {
+		name  string
+		codec Codec
+		val   any
+		// wantGet Get 解码到 any 的结果
+		wantGet    any
+		wantGetErr error
+		dst        func() any
+		wantDst    any
+	}{
+		{
+			name:    "json",
+			codec:   JSONCodec{},
+			val:     codecUser{Name: "Tom", Age: 18},
+			wantGet: map[string]any{"Name": "Tom", "Age": float64(18)},
+			dst:     func() any { return &codecUser{} },
+			wantDst: &codecUser{Name: "Tom", Age: 18},
+		},
+		{
+			name:       "gob",
+			codec:      GobCodec{},
+			val:        codecUser{Name: "Tom", Age: 18},
+			wantGetErr: ErrCacheCodec,
+			dst:        func() any { return &codecUser{} },
+			wantDst:    &codecUser{Name: "Tom", Age: 18},
+		},
+		{
+			name:    "bytes",
+			codec:   BytesCodec{},
+			val:     []byte("raw"),
+			wantGet: []byte("raw"),
+			dst:     func() any { var s string; return &s },
+			wantDst: func() *string { s := "raw"; return &s }(),
+		},
+		{
+			// 用 json 假装是 protobuf
+			name: "proto hook",
+			codec: NewProtoCodec(json.Marshal, func(data []byte, dst any) error {
+				return json.Unmarshal(data, dst.(*codecUser))
+			}),
+			val:        &codecUser{Name: "Tom", Age: 18},
+			wantGetErr: ErrCacheCodec,
+			dst:        func() any { return &codecUser{} },
+			wantDst:    &codecUser{Name: "Tom", Age: 18},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := NewRedisCache(redis.NewClient(&redis.Options{Addr: mr.Addr()}), RedisCacheWithCodec(tc.codec))
+			require.NoError(t, c.Set(ctx, "user", tc.val, time.Minute))
+			raw, err := mr.Get("user")
+			require.NoError(t, err)
+			assert.Equal(t, tc.codec.ID(), raw[0])
+
+			val, err := c.Get(ctx, "user")
+			if tc.wantGetErr != nil {
+				assert.ErrorIs(t, err, tc.wantGetErr)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tc.wantGet, val)
+			}
+			dst := tc.dst()
+			require.NoError(t, c.GetInto(ctx, "user", dst))
+			assert.Equal(t, tc.wantDst, dst)
+		})
+	}
+}
+
+func TestRedisCache_Compression(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+	c := NewRedisCache(redis.NewClient(&redis.Options{Addr: mr.Addr()}),
+		RedisCacheWithCompression(64, nil))
+	small := "short"
+	big := strings.Repeat("compress me ", 100)
+	require.NoError(t, c.SetMulti(ctx, map[string]any{"small": small, "big": big}, time.Minute))
+
+	raw, err := mr.Get("small")
+	require.NoError(t, err)
+	assert.Equal(t, JSONCodecID, raw[0])
+	raw, err = mr.Get("big")
+	require.NoError(t, err)
+	assert.Equal(t, GzipCompressorID<<4|JSONCodecID, raw[0])
+	assert.Less(t, len(raw), len(big))
+
+	res, err := c.GetMulti(ctx, []string{"small", "big"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"small": small, "big": big}, res)
+}
+
+func TestRedisCache_CodecMigration(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	// 加 codec 之前写进去的数据
+	require.NoError(t, NewRedisCache(client).Set(ctx, "legacy", "plain", time.Minute))
+	// 以前用 gob，现在换成 json
+	require.NoError(t, NewRedisCache(client, RedisCacheWithCodec(GobCodec{})).
+		Set(ctx, "old", codecUser{Name: "Tom"}, time.Minute))
+
+	c := NewRedisCache(client, RedisCacheWithCodec(JSONCodec{}))
+	val, err := c.Get(ctx, "legacy")
+	require.NoError(t, err)
+	assert.Equal(t, "plain", val)
+
+	var user codecUser
+	require.NoError(t, c.GetInto(ctx, "old", &user))
+	assert.Equal(t, codecUser{Name: "Tom"}, user)
+
+	var n int
+	assert.ErrorIs(t, c.GetInto(ctx, "legacy", &n), ErrCacheValueType)
+}
+
+func TestTypedCache_DecodingCache(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+	c := NewTypedCache[int, codecUser](NewRedisCache(redis.NewClient(&redis.Options{Addr: mr.Addr()}),
+		RedisCacheWithCodec(JSONCodec{})))
+	require.NoError(t, c.Set(ctx, 1, codecUser{Name: "Tom", Age: 18}, time.Minute))
+	user, err := c.Get(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, codecUser{Name: "Tom", Age: 18}, user)
+}
@@ -0,0 +1,89 @@
+package cache
+
+// countMinSketch 估算key的访问频率，4行计数器，每个计数器最大15（和4bit一样），
+// 估算值取4行里最小的那个。累计增加次数达到 sampleSize 之后所有计数器减半，让旧的热点慢慢冷下去
+type countMinSketch struct {
+	rows       [4][]uint8
+	mask       uint64
+	additions  int
+	sampleSize int
+}
+
+const cmsMaxCount = 15
+
+// newCountMinSketch 每行的宽度取容量4倍向上对齐到2的幂，冲突少一点；采样周期是容量的10倍
+func newCountMinSketch(capacity int) *countMinSketch {
+	width := 64
+	for width < 4*capacity {
+		width <<= 1
+	}
+	s := &countMinSketch{
+		mask:       uint64(width - 1),
+		sampleSize: 10 * capacity,
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+// Increment 频率加一，所有计数器都到上限了就不计入 additions
+func (s *countMinSketch) Increment(key string) {
+	h1, h2 := sketchHash(key)
+	added := false
+	for i := range s.rows {
+		idx := (h1 + uint64(i)*h2) & s.mask
+		if s.rows[i][idx] < cmsMaxCount {
+			s.rows[i][idx]++
+			added = true
+		}
+	}
+	if added {
+		s.additions++
+		if s.additions >= s.sampleSize {
+			s.reset()
+		}
+	}
+}
+
+func (s *countMinSketch) Estimate(key string) uint8 {
+	h1, h2 := sketchHash(key)
+	res := uint8(cmsMaxCount)
+	for i := range s.rows {
+		idx := (h1 + uint64(i)*h2) & s.mask
+		if s.rows[i][idx] < res {
+			res = s.rows[i][idx]
+		}
+	}
+	return res
+}
+
+// reset 衰减，所有计数器减半
+func (s *countMinSketch) reset() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+	s.additions /= 2
+}
+
+// sketchHash fnv-1a，再混淆出第二个哈希做 double hashing
+func sketchHash(key string) (uint64, uint64) {
+	h := fnv64a(key)
+	h2 := (h ^ (h >> 31)) * 0xbf58476d1ce4e5b9
+	return h, h2 | 1
+}
+
+func fnv64a(key string) uint64 {
+	const (
+		offset64 = 14695981039346656037
+		prime64  = 1099511628211
+	)
+	var h uint64 = offset64
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= prime64
+	}
+	return h
+}
@@ -0,0 +1,189 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/xuhaidong1/go-generic-tools/redis_lock"
+	"github.com/xuhaidong1/go-generic-tools/redis_lock/errs"
+	"golang.org/x/sync/singleflight"
+	"time"
+)
+
+// DistributedSingleFlightCache 跨实例的 singleflight：SingleFlightCache 只能保证一个进程里只有一个 goroutine 回源，
+// 200 个实例还是会有 200 个请求打到DB。这里在进程内 singleflight 之外再用 redis_lock 抢一把分布式锁，
+// 抢到的实例回源，没抢到的要么直接返回旧值（开启了 serve-stale 的话），要么每隔 pollInterval 重新读一次缓存，
+// 等待期间锁被释放了（持有锁的实例回源失败）就自己抢锁回源，等了 maxWait 还没等到就认为持有锁的实例挂了，直接本地回源。
+// redis 出问题加不了锁的时候不等，直接本地回源。回源期间每隔 lockExpiration/2 给锁续约，回源比 lockExpiration 慢也不会被别的实例抢走
+type DistributedSingleFlightCache struct {
+	ReadThroughCache
+	g      *singleflight.Group
+	locker *redis_lock.Client
+	//lockExpiration 要比一次回源的时间长，lock.lua 用的是 EX，构造的时候向上取整到整秒
+	lockExpiration time.Duration
+	lockPrefix     string
+	pollInterval   time.Duration
+	maxWait        time.Duration
+	//staleExpiration 大于0的时候开启 serve-stale，回源之后在 {stalePrefix}{key} 多存一份，过期时间比 Expiration 长
+	staleExpiration time.Duration
+	stalePrefix     string
+	rtOpts          []ReadThroughCacheOption
+}
+
+type DistributedSingleFlightCacheOption func(c *DistributedSingleFlightCache)
+
+// DistributedWithLockExpiration 锁的过期时间，默认5秒。不是整秒的向上取整，EX 0 redis 会报错；小于等于0用默认值
+func DistributedWithLockExpiration(expiration time.Duration) DistributedSingleFlightCacheOption {
+	return func(c *DistributedSingleFlightCache) {
+		c.lockExpiration = expiration
+	}
+}
+
+// DistributedWithLockPrefix 锁的key是 prefix+key，默认 "lock:cache:"
+func DistributedWithLockPrefix(prefix string) DistributedSingleFlightCacheOption {
+	return func(c *DistributedSingleFlightCache) {
+		c.lockPrefix = prefix
+	}
+}
+
+// DistributedWithPolling 没抢到锁的时候每隔 interval 读一次缓存，最多等 maxWait，默认 50ms 和 2s
+func DistributedWithPolling(interval, maxWait time.Duration) DistributedSingleFlightCacheOption {
+	return func(c *DistributedSingleFlightCache) {
+		c.pollInterval = interval
+		c.maxWait = maxWait
+	}
+}
+
+// DistributedWithServeStale 开启 serve-stale，没抢到锁的时候直接返回上一次回源的值，
+// 旧值存在 "stale:"+key 下面，过期时间是 staleExpiration，要比 Expiration 长才有意义
+func DistributedWithServeStale(staleExpiration time.Duration) DistributedSingleFlightCacheOption {
+	return func(c *DistributedSingleFlightCache) {
+		c.staleExpiration = staleExpiration
+	}
+}
+
+// DistributedWithReadThroughOptions 负缓存、stale-while-revalidate 之类 ReadThroughCache 的选项
+func DistributedWithReadThroughOptions(opts ...ReadThroughCacheOption) DistributedSingleFlightCacheOption {
+	return func(c *DistributedSingleFlightCache) {
+		c.rtOpts = append(c.rtOpts, opts...)
+	}
+}
+
+func NewDistributedSingleFlightCache(cache Cache, expiration time.Duration, loadFunc LoadFunc,
+	locker *redis_lock.Client, opts ...DistributedSingleFlightCacheOption) *DistributedSingleFlightCache {
+	res := &DistributedSingleFlightCache{
+		g:              &singleflight.Group{},
+		locker:         locker,
+		lockExpiration: time.Second * 5,
+		lockPrefix:     "lock:cache:",
+		pollInterval:   time.Millisecond * 50,
+		maxWait:        time.Second * 2,
+		stalePrefix:    "stale:",
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	if res.lockExpiration <= 0 {
+		res.lockExpiration = time.Second * 5
+	}
+	if rem := res.lockExpiration % time.Second; rem != 0 {
+		res.lockExpiration += time.Second - rem
+	}
+	res.ReadThroughCache = *NewReadThroughCache(cache, expiration, loadFunc, res.rtOpts...)
+	return res
+}
+
+func (c *DistributedSingleFlightCache) Get(ctx context.Context, key string) (any, error) {
+	return c.get(ctx, key, func(ctx context.Context, key string) (any, error) {
+		defer c.g.Forget(key)
+		//进程内先合并一次，一个实例只有一个 goroutine 去抢锁
+		val, err, _ := c.g.Do(key, func() (interface{}, error) {
+			return c.distributedLoad(ctx, key)
+		})
+		return val, err
+	})
+}
+
+func (c *DistributedSingleFlightCache) distributedLoad(ctx context.Context, key string) (any, error) {
+	lockKey := c.lockPrefix + key
+	lock, err := c.locker.TryLock(ctx, lockKey, lockValue(), c.lockExpiration)
+	if err == nil {
+		return c.loadWithLock(ctx, key, lock)
+	}
+	//redis 挂了，等下去也等不到
+	if errors.Is(err, errs.ErrLockUnavailable) {
+		return c.loadLocal(ctx, key)
+	}
+	//别的实例正在回源
+	if c.staleExpiration > 0 {
+		if val, err := c.Cache.Get(ctx, c.stalePrefix+key); err == nil {
+			return val, nil
+		}
+	}
+	deadline := time.Now().Add(c.maxWait)
+	ticker := time.NewTicker(c.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+		val, err := c.Cache.Get(ctx, key)
+		if err == nil {
+			return c.unwrap(key, val)
+		}
+		//缓存本身出问题了，等下去也没用
+		if !IsKeyNotFound(err) {
+			return c.loadLocal(ctx, key)
+		}
+		//锁被释放了缓存里还没有，多半是持有锁的实例回源失败了，换自己来
+		lock, err = c.locker.TryLock(ctx, lockKey, lockValue(), c.lockExpiration)
+		if err == nil {
+			return c.loadWithLock(ctx, key, lock)
+		}
+		if errors.Is(err, errs.ErrLockUnavailable) || time.Now().After(deadline) {
+			return c.loadLocal(ctx, key)
+		}
+	}
+}
+
+// loadWithLock 抢到锁之后再读一次缓存，可能刚好有别的实例回源完释放了锁。持有锁期间后台续约，Unlock 的时候停止
+func (c *DistributedSingleFlightCache) loadWithLock(ctx context.Context, key string, lock *redis_lock.Lock) (any, error) {
+	//续约失败只是有可能被别的实例抢走锁，多回源一次，不影响这次回源
+	go func() {
+		_ = lock.AutoRefresh(c.lockExpiration/2, time.Second)
+	}()
+	defer func() {
+		//请求的ctx可能已经取消了，解锁不能用它。解锁失败也没关系，锁会自己过期
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_ = lock.Unlock(ctx)
+		cancel()
+	}()
+	if val, err := c.Cache.Get(ctx, key); err == nil {
+		return c.unwrap(key, val)
+	}
+	return c.loadLocal(ctx, key)
+}
+
+// loadLocal 回源写缓存，开启了 serve-stale 的话再多存一份旧值
+func (c *DistributedSingleFlightCache) loadLocal(ctx context.Context, key string) (any, error) {
+	val, err := c.load(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if c.staleExpiration > 0 {
+		if err = c.Cache.Set(ctx, c.stalePrefix+key, val, c.staleExpiration); err != nil {
+			c.handleErr(ctx, key, err)
+		}
+	}
+	return val, nil
+}
+
+// lockValue 每次抢锁用一个随机值，保证只能解自己的锁
+func lockValue() string {
+	var buf [16]byte
+	_, _ = rand.Read(buf[:])
+	return hex.EncodeToString(buf[:])
+}
@@ -0,0 +1,210 @@
+package cache
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xuhaidong1/go-generic-tools/redis_lock"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDistributedSingleFlightCache_Get(t *testing.T) {
+	testCases := []struct {
+		name string
+		// before 在 Get 之前准备 redis 里的数据
+		before   func(mr *miniredis.Miniredis)
+		opts     []DistributedSingleFlightCacheOption
+		wantVal  any
+		wantLoad int32
+		// minCost 至少要等这么久
+		minCost time.Duration
+	}{
+		{
+			name:     "hold lock",
+			wantVal:  "value1",
+			wantLoad: 1,
+		},
+		{
+			// 锁一直被别人拿着，等 maxWait 之后自己回源
+			name: "holder dead",
+			before: func(mr *miniredis.Miniredis) {
+				_ = mr.Set("lock:cache:key1", "dead")
+			},
+			opts:     []DistributedSingleFlightCacheOption{DistributedWithPolling(time.Millisecond*10, time.Millisecond*100)},
+			wantVal:  "value1",
+			wantLoad: 1,
+			minCost:  time.Millisecond * 100,
+		},
+		{
+			name: "serve stale",
+			before: func(mr *miniredis.Miniredis) {
+				_ = mr.Set("lock:cache:key1", "other")
+				_ = mr.Set("stale:key1", "old")
+			},
+			opts:     []DistributedSingleFlightCacheOption{DistributedWithServeStale(time.Hour)},
+			wantVal:  "old",
+			wantLoad: 0,
+		},
+		{
+			// 别的实例回源失败释放了锁，等待的实例接着回源
+			name: "holder failed",
+			before: func(mr *miniredis.Miniredis) {
+				_ = mr.Set("lock:cache:key1", "other")
+				go func() {
+					time.Sleep(time.Millisecond * 50)
+					mr.Del("lock:cache:key1")
+				}()
+			},
+			opts:     []DistributedSingleFlightCacheOption{DistributedWithPolling(time.Millisecond*10, time.Second)},
+			wantVal:  "value1",
+			wantLoad: 1,
+			minCost:  time.Millisecond * 50,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mr := miniredis.RunT(t)
+			client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+			if tc.before != nil {
+				tc.before(mr)
+			}
+			var loads int32
+			c := NewDistributedSingleFlightCache(NewRedisCache(client), time.Minute,
+				func(ctx context.Context, key string) (any, error) {
+					atomic.AddInt32(&loads, 1)
+					return "value1", nil
+				}, redis_lock.NewClient(client), tc.opts...)
+			start := time.Now()
+			val, err := c.Get(context.Background(), "key1")
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantVal, val)
+			assert.Equal(t, tc.wantLoad, atomic.LoadInt32(&loads))
+			assert.GreaterOrEqual(t, time.Since(start), tc.minCost)
+		})
+	}
+}
+
+func TestDistributedSingleFlightCache_Instances(t *testing.T) {
+	mr := miniredis.RunT(t)
+	var loads int32
+	loadFunc := func(ctx context.Context, key string) (any, error) {
+		atomic.AddInt32(&loads, 1)
+		time.Sleep(time.Millisecond * 100)
+		return "value1", nil
+	}
+	// 10 个实例，每个实例 5 个并发请求，共享一个 redis
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		c := NewDistributedSingleFlightCache(NewRedisCache(client), time.Minute, loadFunc,
+			redis_lock.NewClient(client), DistributedWithPolling(time.Millisecond*10, time.Second))
+		for j := 0; j < 5; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				val, err := c.Get(context.Background(), "key1")
+				assert.NoError(t, err)
+				assert.Equal(t, "value1", val)
+			}()
+		}
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&loads))
+	// 锁已经释放了
+	assert.False(t, mr.Exists("lock:cache:key1"))
+}
+
+func TestDistributedSingleFlightCache_LockUnavailable(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	mr.Close()
+	var loads int32
+	// 缓存是本地的，只有锁连不上
+	c := NewDistributedSingleFlightCache(NewBuildinMapCache(), time.Minute,
+		func(ctx context.Context, key string) (any, error) {
+			atomic.AddInt32(&loads, 1)
+			return "value1", nil
+		}, redis_lock.NewClient(client), DistributedWithPolling(time.Millisecond*10, time.Second))
+	start := time.Now()
+	val, err := c.Get(context.Background(), "key1")
+	require.NoError(t, err)
+	assert.Equal(t, "value1", val)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&loads))
+	// 不用等 maxWait
+	assert.Less(t, time.Since(start), time.Millisecond*500)
+}
+
+func TestDistributedSingleFlightCache_AutoRefresh(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	release := make(chan struct{})
+	c := NewDistributedSingleFlightCache(NewRedisCache(client), time.Minute,
+		func(ctx context.Context, key string) (any, error) {
+			<-release
+			return "value1", nil
+		}, redis_lock.NewClient(client), DistributedWithLockExpiration(time.Second))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		val, err := c.Get(context.Background(), "key1")
+		assert.NoError(t, err)
+		assert.Equal(t, "value1", val)
+	}()
+	// 每 500ms 续约一次，两次快进加起来超过了锁的过期时间，锁还在
+	time.Sleep(time.Millisecond * 700)
+	mr.FastForward(time.Millisecond * 900)
+	time.Sleep(time.Millisecond * 500)
+	mr.FastForward(time.Millisecond * 900)
+	assert.True(t, mr.Exists("lock:cache:key1"))
+	close(release)
+	<-done
+	assert.False(t, mr.Exists("lock:cache:key1"))
+}
+
+func TestDistributedWithLockExpiration(t *testing.T) {
+	testCases := []struct {
+		name       string
+		expiration time.Duration
+		want       time.Duration
+	}{
+		{name: "whole seconds", expiration: time.Second * 3, want: time.Second * 3},
+		// EX 0 redis 会报错，每次都当成加不了锁
+		{name: "sub second", expiration: time.Millisecond * 300, want: time.Second},
+		{name: "round up", expiration: time.Millisecond * 1500, want: time.Second * 2},
+		// 0 的话续约的 ticker 会 panic
+		{name: "zero", expiration: 0, want: time.Second * 5},
+		{name: "negative", expiration: -time.Second, want: time.Second * 5},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mr := miniredis.RunT(t)
+			client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+			release := make(chan struct{})
+			c := NewDistributedSingleFlightCache(NewRedisCache(client), time.Minute,
+				func(ctx context.Context, key string) (any, error) {
+					<-release
+					return "value1", nil
+				}, redis_lock.NewClient(client), DistributedWithLockExpiration(tc.expiration))
+			assert.Equal(t, tc.want, c.lockExpiration)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				val, err := c.Get(context.Background(), "key1")
+				assert.NoError(t, err)
+				assert.Equal(t, "value1", val)
+			}()
+			// 真的加上了锁，没有退化成本地回源
+			assert.Eventually(t, func() bool {
+				return mr.Exists("lock:cache:key1")
+			}, time.Second, time.Millisecond*10)
+			assert.Equal(t, tc.want, mr.TTL("lock:cache:key1"))
+			close(release)
+			<-done
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xuhaidong1/go-generic-tools/cache/errs"
+	"testing"
+	"time"
+)
+
+func TestErrors_KeyNotFound(t *testing.T) {
+	mr := miniredis.RunT(t)
+	testCases := []struct {
+		name  string
+		cache func() Cache
+		// wantCause 底层的原因也要能匹配上
+		wantCause error
+	}{
+		{
+			name:  "buildin map",
+			cache: func() Cache { return NewBuildinMapCache() },
+		},
+		{
+			name:  "local",
+			cache: func() Cache { return NewLocalCache(nil) },
+		},
+		{
+			name:  "sharded",
+			cache: func() Cache { return newTestShardedCache(4) },
+		},
+		{
+			name:      "redis",
+			cache:     func() Cache { return NewRedisCache(redis.NewClient(&redis.Options{Addr: mr.Addr()})) },
+			wantCause: redis.Nil,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := tc.cache().Get(context.Background(), "key1")
+			assert.True(t, IsKeyNotFound(err))
+			assert.ErrorIs(t, err, errs.ErrKeyNotFound)
+			if tc.wantCause != nil {
+				assert.ErrorIs(t, err, tc.wantCause)
+			}
+			var keyErr *errs.KeyError
+			require.True(t, errors.As(err, &keyErr))
+			assert.Equal(t, "key1", keyErr.Key)
+		})
+	}
+}
+
+func TestErrors_LoadFailed(t *testing.T) {
+	dbErr := errors.New("db down")
+	c := NewReadThroughCache(NewLocalCache(nil), time.Minute,
+		func(ctx context.Context, key string) (any, error) {
+			return nil, dbErr
+		})
+	_, err := c.Get(context.Background(), "key1")
+	assert.ErrorIs(t, err, ErrLoadFailed)
+	assert.ErrorIs(t, err, dbErr)
+	assert.False(t, IsKeyNotFound(err))
+	var keyErr *errs.KeyError
+	require.True(t, errors.As(err, &keyErr))
+	assert.Equal(t, "key1", keyErr.Key)
+}
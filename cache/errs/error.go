@@ -1,7 +1,46 @@
 package errs
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
 
+// 缓存的错误分类，所有实现（包括 RedisCache）都映射到这几个哨兵上，判断的时候用 errors.Is，不要用 ==
+var (
+	ErrKeyNotFound = errors.New("cache:找不到key")
+	ErrClosed      = errors.New("cache:缓存已经被关闭")
+	ErrFull        = errors.New("cache:缓存满了")
+	ErrLoadFailed  = errors.New("cache:无法加载数据")
+	ErrCodec       = errors.New("cache:缓存值编解码失败")
+)
+
+// KeyError 带上key的错误，Err 是上面的哨兵，Cause 是底层的原因（比如 redis.Nil、LoadFunc 返回的错误），可以为nil。
+// errors.Is 对 Err 和 Cause 都能匹配上，errors.As 可以拿到 Key
+type KeyError struct {
+	Key   string
+	Err   error
+	Cause error
+}
+
+func (e *KeyError) Error() string {
+	if e.Cause == nil {
+		return fmt.Sprintf("%v %s", e.Err, e.Key)
+	}
+	return fmt.Sprintf("%v %s: %v", e.Err, e.Key, e.Cause)
+}
+
+func (e *KeyError) Unwrap() []error {
+	if e.Cause == nil {
+		return []error{e.Err}
+	}
+	return []error{e.Err, e.Cause}
+}
+
+func NewKeyError(key string, err, cause error) error {
+	return &KeyError{Key: key, Err: err, Cause: cause}
+}
+
+// NewErrKeyNotFound 每次返回的都是新对象，用 errors.Is(err, ErrKeyNotFound) 判断
 func NewErrKeyNotFound(key string) error {
-	return fmt.Errorf("cache:找不到key %s", key)
+	return &KeyError{Key: key, Err: ErrKeyNotFound}
 }
@@ -0,0 +1,266 @@
+package cache
+
+import (
+	"container/list"
+	"math/rand"
+	"sync"
+)
+
+// EvictionPolicy 淘汰策略，本地缓存满了之后由它选出要淘汰的key。
+// 缓存在key的生命周期里通知策略，策略只负责记录访问情况和挑选受害者，真正的删除还是缓存自己做，
+// 所以淘汰同样会走缓存的 onEvicted 回调。实现需要自己保证并发安全，因为缓存可能在读锁下调用 KeyAccessed
+type EvictionPolicy interface {
+	// KeyAdded 写入了一个新key
+	KeyAdded(key string)
+	// KeyAccessed key被读或者被覆盖写，没命中的读也会通知，方便统计访问频率的策略记录，
+	// 不认识的key不需要调整顺序
+	KeyAccessed(key string)
+	// KeyRemoved key被删除、过期或者淘汰了，不认识的key直接忽略
+	KeyRemoved(key string)
+	// Evict 选出一个要淘汰的key并且不再跟踪它，没有可淘汰的key返回false
+	Evict() (string, bool)
+}
+
+// policyCloner 内置的策略都能创建一个同类型的空策略，ShardedCache 靠它给每个分片一个自己的策略
+type policyCloner interface {
+	newEmpty() EvictionPolicy
+}
+
+// LRU 淘汰最久没有被访问的key
+func LRU() EvictionPolicy {
+	return &lruPolicy{
+		l:     list.New(),
+		nodes: make(map[string]*list.Element),
+	}
+}
+
+// FIFO 淘汰最早写入的key，访问不影响顺序
+func FIFO() EvictionPolicy {
+	return &lruPolicy{
+		l:        list.New(),
+		nodes:    make(map[string]*list.Element),
+		noAccess: true,
+	}
+}
+
+// lruPolicy 链表头是最近访问的，尾部是要淘汰的。FIFO 就是不处理访问的 LRU
+type lruPolicy struct {
+	mutex    sync.Mutex
+	l        *list.List
+	nodes    map[string]*list.Element
+	noAccess bool
+}
+
+func (p *lruPolicy) newEmpty() EvictionPolicy {
+	if p.noAccess {
+		return FIFO()
+	}
+	return LRU()
+}
+
+func (p *lruPolicy) KeyAdded(key string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if node, ok := p.nodes[key]; ok {
+		p.l.MoveToFront(node)
+		return
+	}
+	p.nodes[key] = p.l.PushFront(key)
+}
+
+func (p *lruPolicy) KeyAccessed(key string) {
+	if p.noAccess {
+		return
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if node, ok := p.nodes[key]; ok {
+		p.l.MoveToFront(node)
+	}
+}
+
+func (p *lruPolicy) KeyRemoved(key string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if node, ok := p.nodes[key]; ok {
+		p.l.Remove(node)
+		delete(p.nodes, key)
+	}
+}
+
+func (p *lruPolicy) Evict() (string, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	node := p.l.Back()
+	if node == nil {
+		return "", false
+	}
+	key := p.l.Remove(node).(string)
+	delete(p.nodes, key)
+	return key, true
+}
+
+// LFU 淘汰访问次数最少的key，次数相同的淘汰最久没访问的
+func LFU() EvictionPolicy {
+	return &lfuPolicy{
+		nodes: make(map[string]*list.Element),
+		freqs: make(map[int]*list.List),
+	}
+}
+
+// lfuPolicy 每个访问次数一条链表，记录当前最小次数，增删查都是 O(1)
+type lfuPolicy struct {
+	mutex   sync.Mutex
+	nodes   map[string]*list.Element
+	freqs   map[int]*list.List
+	minFreq int
+}
+
+func (p *lfuPolicy) newEmpty() EvictionPolicy {
+	return LFU()
+}
+
+type lfuEntry struct {
+	key  string
+	freq int
+}
+
+func (p *lfuPolicy) KeyAdded(key string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if _, ok := p.nodes[key]; ok {
+		p.incr(key)
+		return
+	}
+	p.nodes[key] = p.bucket(1).PushFront(&lfuEntry{key: key, freq: 1})
+	p.minFreq = 1
+}
+
+func (p *lfuPolicy) KeyAccessed(key string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if _, ok := p.nodes[key]; ok {
+		p.incr(key)
+	}
+}
+
+func (p *lfuPolicy) KeyRemoved(key string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	node, ok := p.nodes[key]
+	if !ok {
+		return
+	}
+	p.remove(node)
+}
+
+func (p *lfuPolicy) Evict() (string, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if len(p.nodes) == 0 {
+		return "", false
+	}
+	l, ok := p.freqs[p.minFreq]
+	for !ok || l.Len() == 0 {
+		//minFreq 只在删除的时候可能失效，往上找一个非空的桶
+		p.minFreq++
+		l, ok = p.freqs[p.minFreq]
+	}
+	node := l.Back()
+	entry := node.Value.(*lfuEntry)
+	p.remove(node)
+	return entry.key, true
+}
+
+func (p *lfuPolicy) incr(key string) {
+	node := p.nodes[key]
+	entry := node.Value.(*lfuEntry)
+	old := p.freqs[entry.freq]
+	old.Remove(node)
+	if old.Len() == 0 {
+		delete(p.freqs, entry.freq)
+		if p.minFreq == entry.freq {
+			p.minFreq++
+		}
+	}
+	entry.freq++
+	p.nodes[key] = p.bucket(entry.freq).PushFront(entry)
+}
+
+func (p *lfuPolicy) remove(node *list.Element) {
+	entry := node.Value.(*lfuEntry)
+	l := p.freqs[entry.freq]
+	l.Remove(node)
+	if l.Len() == 0 {
+		delete(p.freqs, entry.freq)
+	}
+	delete(p.nodes, entry.key)
+}
+
+func (p *lfuPolicy) bucket(freq int) *list.List {
+	l, ok := p.freqs[freq]
+	if !ok {
+		l = list.New()
+		p.freqs[freq] = l
+	}
+	return l
+}
+
+// Random 随机淘汰一个key，不需要维护访问顺序，开销最小
+func Random() EvictionPolicy {
+	return &randomPolicy{
+		idx: make(map[string]int),
+	}
+}
+
+// randomPolicy 切片存key，map记录下标，删除的时候和最后一个交换
+type randomPolicy struct {
+	mutex sync.Mutex
+	keys  []string
+	idx   map[string]int
+}
+
+func (p *randomPolicy) newEmpty() EvictionPolicy {
+	return Random()
+}
+
+func (p *randomPolicy) KeyAdded(key string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if _, ok := p.idx[key]; ok {
+		return
+	}
+	p.idx[key] = len(p.keys)
+	p.keys = append(p.keys, key)
+}
+
+func (p *randomPolicy) KeyAccessed(key string) {}
+
+func (p *randomPolicy) KeyRemoved(key string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.remove(key)
+}
+
+func (p *randomPolicy) Evict() (string, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if len(p.keys) == 0 {
+		return "", false
+	}
+	key := p.keys[rand.Intn(len(p.keys))]
+	p.remove(key)
+	return key, true
+}
+
+func (p *randomPolicy) remove(key string) {
+	i, ok := p.idx[key]
+	if !ok {
+		return
+	}
+	last := len(p.keys) - 1
+	p.keys[i] = p.keys[last]
+	p.idx[p.keys[i]] = i
+	p.keys = p.keys[:last]
+	delete(p.idx, key)
+}
@@ -0,0 +1,106 @@
+package cache
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestEvictionPolicy_Evict(t *testing.T) {
+	testCases := []struct {
+		name   string
+		policy EvictionPolicy
+		// 依次写入 key1 key2 key3，再访问 key1 两次、key2 一次
+		wantOrder []string
+	}{
+		{
+			name:      "lru",
+			policy:    LRU(),
+			wantOrder: []string{"key3", "key2", "key1"},
+		},
+		{
+			name:      "lfu",
+			policy:    LFU(),
+			wantOrder: []string{"key3", "key2", "key1"},
+		},
+		{
+			name:      "fifo",
+			policy:    FIFO(),
+			wantOrder: []string{"key1", "key2", "key3"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := tc.policy
+			p.KeyAdded("key1")
+			p.KeyAdded("key2")
+			p.KeyAdded("key3")
+			p.KeyAccessed("key1")
+			p.KeyAccessed("key2")
+			p.KeyAccessed("key1")
+			var order []string
+			for {
+				key, ok := p.Evict()
+				if !ok {
+					break
+				}
+				order = append(order, key)
+			}
+			assert.Equal(t, tc.wantOrder, order)
+		})
+	}
+}
+
+func TestRandomPolicy_Evict(t *testing.T) {
+	p := Random()
+	p.KeyAdded("key1")
+	p.KeyAdded("key2")
+	p.KeyAdded("key3")
+	p.KeyRemoved("key2")
+	evicted := map[string]bool{}
+	for {
+		key, ok := p.Evict()
+		if !ok {
+			break
+		}
+		evicted[key] = true
+	}
+	assert.Equal(t, map[string]bool{"key1": true, "key3": true}, evicted)
+}
+
+func TestBuildinMapCache_MaxEntries(t *testing.T) {
+	var evicted []string
+	c := NewBuildinMapCache(WithMaxEntries(2), WithOnEvicted(func(key string, val any) {
+		evicted = append(evicted, key)
+	}))
+	ctx := context.Background()
+	require.NoError(t, c.Set(ctx, "key1", 1, time.Minute))
+	require.NoError(t, c.Set(ctx, "key2", 2, time.Minute))
+	_, err := c.Get(ctx, "key1")
+	require.NoError(t, err)
+	// 满了也能写进去，最久没访问的 key2 被淘汰
+	require.NoError(t, c.Set(ctx, "key3", 3, time.Minute))
+	assert.Equal(t, []string{"key2"}, evicted)
+	_, err = c.Get(ctx, "key2")
+	assert.ErrorIs(t, err, ErrCacheKeyNotExist)
+	val, err := c.Get(ctx, "key3")
+	require.NoError(t, err)
+	assert.Equal(t, 3, val)
+}
+
+func TestMaxCntCache_Set(t *testing.T) {
+	var evicted []string
+	c := NewMaxCntCache(2, MaxCntCacheWithEvictionPolicy(FIFO()),
+		MaxCntCacheWithOnEvicted(func(key string, val any) {
+			evicted = append(evicted, key)
+		}))
+	ctx := context.Background()
+	require.NoError(t, c.Set(ctx, "key1", 1, time.Minute))
+	require.NoError(t, c.Set(ctx, "key2", 2, time.Minute))
+	require.NoError(t, c.Set(ctx, "key1", 11, time.Minute))
+	require.NoError(t, c.Set(ctx, "key3", 3, time.Minute))
+	assert.Equal(t, []string{"key1"}, evicted)
+	assert.Equal(t, int32(2), c.count)
+}
@@ -3,6 +3,8 @@ package cache
 import (
 	"context"
 	"github.com/xuhaidong1/go-generic-tools/cache/errs"
+	"github.com/xuhaidong1/go-generic-tools/container/queue"
+	"io"
 	"sync"
 	"time"
 )
@@ -15,16 +17,72 @@ type LocalCache struct {
 	close     chan struct{}
 	closeOnce sync.Once
 	onEvicted func(key string, val any)
+	recorder  StatsRecorder
+	//snapshotCodec 快照里值的序列化方式
+	snapshotCodec Codec
+	snapshotFile  *snapshotFile
+	//wheel 不为nil的时候用时间轮过期，不再轮询
+	wheelTick time.Duration
+	wheelSize int
+	wheel     *queue.TimingWheel[string]
+	tags      tagIndex
 }
 
-func NewLocalCache(onEvicted func(key string, val any)) *LocalCache {
-	ticker := time.NewTicker(time.Second)
+type LocalCacheOption func(l *LocalCache)
+
+// LocalCacheWithStatsRecorder 替换默认的统计埋点
+func LocalCacheWithStatsRecorder(recorder StatsRecorder) LocalCacheOption {
+	return func(l *LocalCache) {
+		l.recorder = recorder
+	}
+}
+
+// LocalCacheWithSnapshotCodec 快照里值的序列化方式，默认 GobAnyCodec
+func LocalCacheWithSnapshotCodec(codec Codec) LocalCacheOption {
+	return func(l *LocalCache) {
+		l.snapshotCodec = codec
+	}
+}
+
+// LocalCacheWithSnapshotFile 创建的时候从 path 恢复，之后每隔 interval 写一次快照，Close 的时候再写一次
+func LocalCacheWithSnapshotFile(path string, interval time.Duration) LocalCacheOption {
+	return func(l *LocalCache) {
+		l.snapshotFile = newSnapshotFile(path, interval, l)
+	}
+}
+
+// LocalCacheWithTimingWheel 用分层时间轮精确过期，代替每秒最多扫2000个key的轮询，key很多的时候用它
+func LocalCacheWithTimingWheel(tick time.Duration, wheelSize int) LocalCacheOption {
+	return func(l *LocalCache) {
+		l.wheelTick = tick
+		l.wheelSize = wheelSize
+	}
+}
+
+func NewLocalCache(onEvicted func(key string, val any), opts ...LocalCacheOption) *LocalCache {
 	ch := make(chan struct{})
 	res := &LocalCache{
-		close:     ch,
-		onEvicted: onEvicted,
+		data:          make(map[string]any),
+		close:         ch,
+		onEvicted:     onEvicted,
+		recorder:      NewStatsRecorder(),
+		snapshotCodec: GobAnyCodec{},
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	res.recorder = orNoopRecorder(res.recorder)
+	if res.wheelTick > 0 {
+		res.wheel = queue.NewTimingWheel[string](res.wheelTick, res.wheelSize, res.expire)
+	}
+	if res.snapshotFile != nil {
+		res.snapshotFile.start()
+	}
+	if res.wheel != nil {
+		return res
 	}
 	//开一个goroutine用于删除过期的key
+	ticker := time.NewTicker(time.Second)
 	go func() {
 		for {
 			select {
@@ -34,7 +92,7 @@ func NewLocalCache(onEvicted func(key string, val any)) *LocalCache {
 				for key, val := range res.data {
 					itm := val.(*item)
 					if itm.Deadline.Before(time.Now()) {
-						res.delete(key, itm)
+						res.delete(key, itm, EvictionReasonExpired)
 					}
 					cnt++
 					if cnt > 2000 {
@@ -56,6 +114,7 @@ func (l *LocalCache) Get(ctx context.Context, key string) (any, error) {
 	itm, ok := l.data[key]
 	l.mutex.RUnlock()
 	if !ok {
+		l.recorder.RecordMisses(1)
 		return nil, errs.NewErrKeyNotFound(key)
 	}
 	res := itm.(*item)
@@ -69,23 +128,64 @@ func (l *LocalCache) Get(ctx context.Context, key string) (any, error) {
 		}
 		res := itm.(*item)
 		if res.Deadline.Before(time.Now()) {
-			delete(l.data, key)
+			l.delete(key, itm, EvictionReasonExpired)
 		}
+		l.recorder.RecordMisses(1)
 		return nil, errs.NewErrKeyNotFound(key)
 	}
+	l.recorder.RecordHits(1)
 	return res.Val, nil
 }
 
 func (l *LocalCache) Set(ctx context.Context, key string, val any, expiration time.Duration) error {
 	l.mutex.Lock()
 	defer l.mutex.Unlock()
-	l.data[key] = &item{
-		Val:      val,
-		Deadline: time.Now().Add(expiration),
+	l.set(key, val, time.Now().Add(expiration))
+	return nil
+}
+
+func (l *LocalCache) SetWithTags(ctx context.Context, key string, val any, expiration time.Duration, tags ...string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.set(key, val, time.Now().Add(expiration))
+	l.tags.add(key, tags)
+	return nil
+}
+
+func (l *LocalCache) InvalidateTags(ctx context.Context, tags ...string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for _, key := range l.tags.keysOf(tags) {
+		l.delete(key, l.data[key], EvictionReasonDeleted)
 	}
 	return nil
 }
 
+// set 调用方需要持有写锁
+func (l *LocalCache) set(key string, val any, dl time.Time) {
+	if old, ok := l.data[key]; ok {
+		old.(*item).stopTimer()
+	}
+	itm := &item{
+		Val:      val,
+		Deadline: dl,
+	}
+	if l.wheel != nil {
+		itm.timer, _ = l.wheel.AddAt(dl, key)
+	}
+	l.data[key] = itm
+}
+
+// expire 时间轮的到期回调，key 可能已经被覆盖写了，要再检查一次
+func (l *LocalCache) expire(key string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	val, ok := l.data[key]
+	if ok && val.(*item).Deadline.Before(time.Now()) {
+		l.delete(key, val, EvictionReasonExpired)
+	}
+}
+
 func (l *LocalCache) Delete(ctx context.Context, key string) error {
 	l.mutex.Lock()
 	defer l.mutex.Unlock()
@@ -93,23 +193,135 @@ func (l *LocalCache) Delete(ctx context.Context, key string) error {
 	if !ok {
 		return nil
 	}
-	l.delete(key, val)
+	l.delete(key, val, EvictionReasonDeleted)
 	return nil
 }
 
-func (l *LocalCache) delete(key string, val any) {
+// GetMulti 只加一次读锁，过期了还没删的当作没命中，留给轮询删除
+func (l *LocalCache) GetMulti(ctx context.Context, keys []string) (map[string]any, error) {
+	res := make(map[string]any, len(keys))
+	now := time.Now()
+	l.mutex.RLock()
+	for _, key := range keys {
+		itm, ok := l.data[key]
+		if ok && !itm.(*item).Deadline.Before(now) {
+			res[key] = itm.(*item).Val
+		}
+	}
+	l.mutex.RUnlock()
+	l.recorder.RecordHits(len(res))
+	l.recorder.RecordMisses(len(keys) - len(res))
+	return res, nil
+}
+
+func (l *LocalCache) SetMulti(ctx context.Context, entries map[string]any, expiration time.Duration) error {
+	dl := time.Now().Add(expiration)
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for key, val := range entries {
+		l.set(key, val, dl)
+	}
+	return nil
+}
+
+func (l *LocalCache) DeleteMulti(ctx context.Context, keys ...string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for _, key := range keys {
+		if val, ok := l.data[key]; ok {
+			l.delete(key, val, EvictionReasonDeleted)
+		}
+	}
+	return nil
+}
+
+func (l *LocalCache) delete(key string, val any, reason EvictionReason) {
 	delete(l.data, key)
+	val.(*item).stopTimer()
+	l.tags.remove(key)
+	l.recorder.RecordEviction(reason)
 	if l.onEvicted != nil {
 		l.onEvicted(key, val.(*item).Val)
 	}
 }
 
-// Close 需要考虑用户重复close
+func (l *LocalCache) Stats() Stats {
+	res := l.recorder.Snapshot()
+	l.mutex.RLock()
+	res.Entries = len(l.data)
+	l.mutex.RUnlock()
+	return res
+}
+
+// Range 在读锁里拷一份没过期的key和值，fn 在锁外面调用，看到的是调用那一刻的快照，fn 返回false停止
+func (l *LocalCache) Range(ctx context.Context, fn func(key string, val any) bool) error {
+	now := time.Now()
+	l.mutex.RLock()
+	entries := make([]snapshotEntry, 0, len(l.data))
+	for key, val := range l.data {
+		if itm := val.(*item); !itm.Deadline.Before(now) {
+			entries = append(entries, snapshotEntry{key: key, val: itm.Val})
+		}
+	}
+	l.mutex.RUnlock()
+	return rangeEntries(ctx, entries, fn)
+}
+
+func (l *LocalCache) Scan(ctx context.Context, pattern string, cursor uint64, count int64) ([]string, uint64, error) {
+	s := newKeyScanner(pattern, cursor)
+	now := time.Now()
+	l.mutex.RLock()
+	for key, val := range l.data {
+		if !val.(*item).Deadline.Before(now) {
+			s.add(key)
+		}
+	}
+	l.mutex.RUnlock()
+	keys, next := s.result(count)
+	return keys, next, nil
+}
+
+func (l *LocalCache) Snapshot(w io.Writer) error {
+	now := time.Now()
+	l.mutex.RLock()
+	entries := make([]snapshotEntry, 0, len(l.data))
+	for key, val := range l.data {
+		itm := val.(*item)
+		if !itm.Deadline.Before(now) {
+			entries = append(entries, snapshotEntry{key: key, val: itm.Val, deadline: itm.Deadline})
+		}
+	}
+	l.mutex.RUnlock()
+	return writeSnapshot(w, l.snapshotCodec, entries)
+}
+
+func (l *LocalCache) Restore(r io.Reader) error {
+	entries, err := readSnapshot(r, l.snapshotCodec)
+	if err != nil {
+		return err
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for _, e := range entries {
+		l.set(e.key, e.val, e.deadline)
+	}
+	return nil
+}
+
+// Close 需要考虑用户重复close，配置了快照文件的话先写最后一次快照
 func (l *LocalCache) Close() error {
+	var err error
+	if l.snapshotFile != nil {
+		err = l.snapshotFile.stop()
+	}
 	l.closeOnce.Do(func() {
+		if l.wheel != nil {
+			l.wheel.Close()
+			return
+		}
 		l.close <- struct{}{}
 	})
-	return nil
+	return err
 	//select{
 	//case l.close<- struct{}{}:
 	//default:
@@ -2,7 +2,10 @@ package cache
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"github.com/xuhaidong1/go-generic-tools/cache/errs"
+	cerrs "github.com/xuhaidong1/go-generic-tools/container/errs"
 	"github.com/xuhaidong1/go-generic-tools/container/queue"
 	"log"
 	"sync"
@@ -21,8 +24,19 @@ type LocalCacheDelayQueue struct {
 	onEvicted func(key string, val any)
 	//轮询时间间隔
 	//cycleInterval time.Duration
-	//延时队列
+	//延时队列，不限容量。覆盖写、淘汰的key在队列里的旧元素不会马上删，到期之后 deleteExpired 发现对不上就跳过
 	delayQueue *queue.DelayQueue[itemDelay]
+	//满了之后的淘汰策略，默认 LRU
+	policy EvictionPolicy
+}
+
+type LocalCacheDelayQueueOption func(c *LocalCacheDelayQueue)
+
+// LocalCacheDelayQueueWithEvictionPolicy 指定满了之后的淘汰策略
+func LocalCacheDelayQueueWithEvictionPolicy(policy EvictionPolicy) LocalCacheDelayQueueOption {
+	return func(c *LocalCacheDelayQueue) {
+		c.policy = policy
+	}
 }
 
 type itemDelay struct {
@@ -35,12 +49,19 @@ func (i itemDelay) Delay() time.Duration {
 	return i.deadline.Sub(time.Now())
 }
 
-func NewLocalCacheDelayQueue(size int) *LocalCacheDelayQueue {
+func NewLocalCacheDelayQueue(size int, opts ...LocalCacheDelayQueueOption) *LocalCacheDelayQueue {
 	res := &LocalCacheDelayQueue{
-		data: make(map[string]*itemDelay),
+		data:  make(map[string]*itemDelay),
+		size:  int32(size),
+		close: make(chan struct{}),
 		//cycleInterval: time.Second * 10,
-		delayQueue: queue.NewDelayQueue[itemDelay](size),
+		delayQueue: queue.NewDelayQueue[itemDelay](0),
+		policy:     LRU(),
+	}
+	for _, opt := range opts {
+		opt(res)
 	}
+	setPolicyCapacity(res.policy, size)
 	res.onEvicted = func(key string, val any) {
 		atomic.AddInt32(&res.count, -1)
 	}
@@ -48,20 +69,6 @@ func NewLocalCacheDelayQueue(size int) *LocalCacheDelayQueue {
 }
 
 func (c *LocalCacheDelayQueue) Set(ctx context.Context, key string, val any, expiration time.Duration) error {
-	c.lock.Lock()
-	defer c.lock.Unlock()
-	if c.closed {
-		return ErrCacheClosed
-	}
-
-	if _, ok := c.data[key]; !ok {
-		cnt := atomic.AddInt32(&c.count, 1)
-		//先加再减
-		if cnt > c.size {
-			atomic.AddInt32(&c.count, -1)
-			return ErrCacheFull
-		}
-	}
 	var dl time.Time
 	if expiration > 0 {
 		dl = time.Now().Add(expiration)
@@ -71,12 +78,43 @@ func (c *LocalCacheDelayQueue) Set(ctx context.Context, key string, val any, exp
 		val:      val,
 		deadline: dl,
 	}
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return ErrCacheClosed
+	}
+	c.set(itm)
+	c.lock.Unlock()
+	//没有过期时间的key不需要进延时队列。
+	//入队放在锁外面，出队的 goroutine 删过期key要拿锁。入队失败key也已经写进去了，过期了 Get 的时候照样会删
+	if dl.IsZero() {
+		return nil
+	}
 	err := c.delayQueue.Enqueue(ctx, itm)
-	if err != nil {
-		return err
+	if errors.Is(err, cerrs.ErrFullQueue) {
+		return fmt.Errorf("%w: %w", ErrCacheFull, err)
+	}
+	return err
+}
+
+// set 调用方需要持有写锁
+func (c *LocalCacheDelayQueue) set(itm itemDelay) {
+	if _, ok := c.data[itm.key]; ok {
+		c.data[itm.key] = &itm
+		c.policy.KeyAccessed(itm.key)
+		return
+	}
+	c.data[itm.key] = &itm
+	c.policy.KeyAdded(itm.key)
+	//满了就淘汰，淘汰的时候回调会把计数减回去
+	atomic.AddInt32(&c.count, 1)
+	for atomic.LoadInt32(&c.count) > c.size {
+		victim, ok := c.policy.Evict()
+		if !ok {
+			break
+		}
+		c.delete(victim)
 	}
-	c.data[key] = &itm
-	return nil
 }
 
 func (c *LocalCacheDelayQueue) Get(ctx context.Context, key string) (any, error) {
@@ -86,8 +124,9 @@ func (c *LocalCacheDelayQueue) Get(ctx context.Context, key string) (any, error)
 	c.lock.RLock()
 	itm, ok := c.data[key]
 	c.lock.RUnlock()
+	c.policy.KeyAccessed(key)
 	if !ok {
-		return nil, ErrCacheKeyNotExist
+		return nil, errs.NewErrKeyNotFound(key)
 	}
 	// double check 以防别的 goroutine 设置值了
 	now := time.Now()
@@ -99,11 +138,11 @@ func (c *LocalCacheDelayQueue) Get(ctx context.Context, key string) (any, error)
 		}
 		itm, ok = c.data[key]
 		if !ok {
-			return nil, ErrCacheKeyNotExist
+			return nil, errs.NewErrKeyNotFound(key)
 		}
 		if itm.deadlineBefore(now) {
 			c.delete(key)
-			return nil, ErrCacheKeyNotExist
+			return nil, errs.NewErrKeyNotFound(key)
 		}
 	}
 	return itm.val, nil
@@ -122,7 +161,7 @@ func (c *LocalCacheDelayQueue) AutoExpire() {
 				if err != nil {
 					log.Fatalln(fmt.Sprintf("localcache delayqueue err %s", err))
 				}
-				err = c.Delete(context.Background(), itm.key)
+				err = c.deleteExpired(itm)
 				if err != nil {
 					log.Fatalln(fmt.Sprintf("localcache delayqueue delete err %s", err))
 				}
@@ -141,11 +180,26 @@ func (c *LocalCacheDelayQueue) Delete(ctx context.Context, key string) error {
 	return nil
 }
 
+// deleteExpired 延时队列里的元素可能已经被覆盖写或者淘汰了，只有deadline对得上才删
+func (c *LocalCacheDelayQueue) deleteExpired(expired itemDelay) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return ErrCacheClosed
+	}
+	itm, ok := c.data[expired.key]
+	if ok && itm.deadline.Equal(expired.deadline) {
+		c.delete(expired.key)
+	}
+	return nil
+}
+
 func (c *LocalCacheDelayQueue) delete(key string) {
 	//log.Printf("mapCache 中的delete %s\n", key)
 	itm, ok := c.data[key]
 	if ok {
 		delete(c.data, key)
+		c.policy.KeyRemoved(key)
 		if c.onEvicted != nil {
 			c.onEvicted(key, itm.val)
 		}
@@ -0,0 +1,28 @@
+package cache
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestLocalCacheDelayQueue_SetPastCapacity(t *testing.T) {
+	ctx := context.Background()
+	c := NewLocalCacheDelayQueue(2)
+	c.AutoExpire()
+	// 淘汰、覆盖写的key在延时队列里的旧元素不能占着容量
+	for _, key := range []string{"key1", "key2", "key3", "key4", "key4", "key1"} {
+		require.NoError(t, c.Set(ctx, key, key, time.Minute))
+	}
+	assert.ElementsMatch(t, []any{"key4", "key1"}, c.keysAsSlice())
+
+	require.NoError(t, c.Set(ctx, "key5", "key5", time.Millisecond*10))
+	assert.Eventually(t, func() bool {
+		c.lock.RLock()
+		defer c.lock.RUnlock()
+		_, ok := c.data["key5"]
+		return !ok
+	}, time.Second, time.Millisecond*10)
+}
@@ -0,0 +1,18 @@
+--- KEYS[1] 缓存的key
+--- ARGV[1] 期望的版本号（16位十六进制），ARGV[2] 新值，ARGV[3] 过期时间（毫秒），小于等于0表示不过期
+--- 版本号是值的 sha1 的前16位，key 不存在的时候是全0。写成功返回1，版本号对不上返回0
+local ver = string.rep("0", 16)
+local cur = redis.call("get", KEYS[1])
+if cur then
+    ver = string.sub(redis.sha1hex(cur), 1, 16)
+end
+if ver ~= ARGV[1] then
+    return 0
+end
+local ttl = tonumber(ARGV[3])
+if ttl > 0 then
+    redis.call("set", KEYS[1], ARGV[2], "PX", ttl)
+else
+    redis.call("set", KEYS[1], ARGV[2])
+end
+return 1
@@ -0,0 +1,14 @@
+--- KEYS[1] 缓存的key
+--- ARGV[1] 值，ARGV[2] 过期时间（毫秒），小于等于0表示不过期
+--- key 存在返回现在的值，不存在写入之后返回 nil
+local cur = redis.call("get", KEYS[1])
+if cur then
+    return cur
+end
+local ttl = tonumber(ARGV[2])
+if ttl > 0 then
+    redis.call("set", KEYS[1], ARGV[1], "PX", ttl)
+else
+    redis.call("set", KEYS[1], ARGV[1])
+end
+return false
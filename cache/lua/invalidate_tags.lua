@@ -0,0 +1,11 @@
+--- KEYS 标签对应的 set，把 set 里的key和 set 本身都删掉，返回删掉的缓存key的个数
+--- unpack 参数个数有上限，分批删
+local n = 0
+for i = 1, #KEYS do
+    local members = redis.call("smembers", KEYS[i])
+    for j = 1, #members, 1000 do
+        n = n + redis.call("del", unpack(members, j, math.min(j + 999, #members)))
+    end
+    redis.call("del", KEYS[i])
+end
+return n
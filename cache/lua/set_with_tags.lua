@@ -0,0 +1,20 @@
+--- KEYS[1] 缓存的key，KEYS[2..] 标签对应的 set
+--- ARGV[1] 值，ARGV[2] 过期时间（毫秒），小于等于0表示不过期
+--- 标签 set 的过期时间不能比里面任何一个key短，不然标签先没了，失效的时候就漏删了
+local ttl = tonumber(ARGV[2])
+if ttl > 0 then
+    redis.call("set", KEYS[1], ARGV[1], "PX", ttl)
+else
+    redis.call("set", KEYS[1], ARGV[1])
+end
+for i = 2, #KEYS do
+    --  -2 不存在，-1 不过期
+    local cur = redis.call("pttl", KEYS[i])
+    redis.call("sadd", KEYS[i], KEYS[1])
+    if ttl <= 0 then
+        redis.call("persist", KEYS[i])
+    elseif cur == -2 or (cur >= 0 and cur < ttl) then
+        redis.call("pexpire", KEYS[i], ttl)
+    end
+end
+return "OK"
@@ -2,42 +2,87 @@ package cache
 
 import (
 	"context"
-	"errors"
-	"github.com/xuhaidong1/go-generic-tools/cache/errs"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
+// MaxCntCache 限制key数量的cache，满了之后按淘汰策略淘汰旧key给新key腾位置，淘汰会走 onEvicted 回调
 type MaxCntCache struct {
 	Cache
 	mutex    sync.Mutex
 	MaxCount int32
 	count    int32
+	policy   EvictionPolicy
+	//onEvicted 用户的回调，淘汰、过期、删除都会触发
+	onEvicted func(key string, val any)
 }
 
-func NewMaxCntCache(maxcount int32) *MaxCntCache {
+type MaxCntCacheOption func(m *MaxCntCache)
+
+// MaxCntCacheWithEvictionPolicy 指定淘汰策略，默认 LRU
+func MaxCntCacheWithEvictionPolicy(policy EvictionPolicy) MaxCntCacheOption {
+	return func(m *MaxCntCache) {
+		m.policy = policy
+	}
+}
+
+func MaxCntCacheWithOnEvicted(onEvicted func(key string, val any)) MaxCntCacheOption {
+	return func(m *MaxCntCache) {
+		m.onEvicted = onEvicted
+	}
+}
+
+func NewMaxCntCache(maxcount int32, opts ...MaxCntCacheOption) *MaxCntCache {
 	res := &MaxCntCache{
 		MaxCount: maxcount,
+		policy:   LRU(),
+	}
+	for _, opt := range opts {
+		opt(res)
 	}
+	setPolicyCapacity(res.policy, int(maxcount))
 	//因为localcache有多个地方都会delete，应该里面delete的时候也要控制外面计数
 	res.Cache = NewLocalCache(func(key string, val any) {
-		atomic.AddInt32(&res.MaxCount, -1)
+		atomic.AddInt32(&res.count, -1)
+		res.policy.KeyRemoved(key)
+		if res.onEvicted != nil {
+			res.onEvicted(key, val)
+		}
 	})
 	return res
 }
 
+func (m *MaxCntCache) Get(ctx context.Context, key string) (any, error) {
+	m.policy.KeyAccessed(key)
+	return m.Cache.Get(ctx, key)
+}
+
 func (m *MaxCntCache) Set(ctx context.Context, key string, val any, expiration time.Duration) error {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 	_, err := m.Cache.Get(ctx, key)
-	if err != nil && err == errs.NewErrKeyNotFound(key) {
-		cnt := atomic.AddInt32(&m.count, 1)
-		//先加再减
-		if cnt > m.MaxCount {
-			atomic.AddInt32(&m.count, -1)
-			return errors.New("")
+	if err != nil && !IsKeyNotFound(err) {
+		return err
+	}
+	if err == nil {
+		m.policy.KeyAccessed(key)
+		return m.Cache.Set(ctx, key, val, expiration)
+	}
+	//先写再淘汰，淘汰的时候回调会把计数减回去
+	if err = m.Cache.Set(ctx, key, val, expiration); err != nil {
+		return err
+	}
+	atomic.AddInt32(&m.count, 1)
+	m.policy.KeyAdded(key)
+	for atomic.LoadInt32(&m.count) > m.MaxCount {
+		victim, ok := m.policy.Evict()
+		if !ok {
+			break
+		}
+		if err = m.Cache.Delete(ctx, victim); err != nil {
+			return err
 		}
 	}
-	return m.Cache.Set(ctx, key, val, expiration)
+	return nil
 }
@@ -0,0 +1,179 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"github.com/xuhaidong1/go-generic-tools/cache/errs"
+	"log"
+	"sync"
+	"time"
+)
+
+// MultiLevelCache 两级缓存：本地 L1 + Redis L2。读的时候 L1 -> L2 -> LoadFunc，逐级回填。
+// 多实例部署的时候，别的实例写了数据，本实例 L1 里的就是脏数据，
+// 所以 Set/Delete 之后会往 Redis 的一个频道上广播失效消息，所有实例都订阅这个频道，收到之后删掉自己 L1 里的key
+type MultiLevelCache struct {
+	L1 Cache
+	L2 Cache
+	//LoadFunc 可以不设置，不设置的时候两级都没有就返回 key 不存在
+	LoadFunc
+	//Expiration L2 和回源之后写缓存的过期时间，L1Expiration L1 的过期时间，一般比 L2 短，兜底没收到失效消息的情况
+	Expiration   time.Duration
+	L1Expiration time.Duration
+
+	client  redis.UniversalClient
+	channel string
+	//id 实例标识，收到自己发的失效消息直接忽略
+	id     string
+	pubsub *redis.PubSub
+	done   chan struct{}
+	once   sync.Once
+}
+
+type MultiLevelCacheOption func(c *MultiLevelCache)
+
+// MultiLevelCacheWithLoadFunc 两级都没命中的时候回源
+func MultiLevelCacheWithLoadFunc(loadFunc LoadFunc) MultiLevelCacheOption {
+	return func(c *MultiLevelCache) {
+		c.LoadFunc = loadFunc
+	}
+}
+
+func MultiLevelCacheWithExpiration(expiration, l1Expiration time.Duration) MultiLevelCacheOption {
+	return func(c *MultiLevelCache) {
+		c.Expiration = expiration
+		c.L1Expiration = l1Expiration
+	}
+}
+
+// MultiLevelCacheWithChannel 失效消息的频道，同一组缓存的实例要用同一个频道
+func MultiLevelCacheWithChannel(channel string) MultiLevelCacheOption {
+	return func(c *MultiLevelCache) {
+		c.channel = channel
+	}
+}
+
+// invalidation 失效消息
+type invalidation struct {
+	Source string   `json:"source"`
+	Keys   []string `json:"keys"`
+}
+
+// NewMultiLevelCache client 用来订阅和发布失效消息，l2 一般就是用同一个 client 建的 RedisCache。
+// 订阅成功之后才返回，保证返回之后别的实例发的失效消息都能收到
+func NewMultiLevelCache(ctx context.Context, l1, l2 Cache, client redis.UniversalClient,
+	opts ...MultiLevelCacheOption) (*MultiLevelCache, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	res := &MultiLevelCache{
+		L1:           l1,
+		L2:           l2,
+		Expiration:   time.Minute * 10,
+		L1Expiration: time.Minute,
+		client:       client,
+		channel:      "cache:invalidation",
+		id:           hex.EncodeToString(id),
+		done:         make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	res.pubsub = client.Subscribe(ctx, res.channel)
+	//等订阅确认
+	if _, err := res.pubsub.Receive(ctx); err != nil {
+		_ = res.pubsub.Close()
+		return nil, fmt.Errorf("cache:订阅失效频道失败 %w", err)
+	}
+	go res.listen()
+	return res, nil
+}
+
+func (c *MultiLevelCache) listen() {
+	defer close(c.done)
+	for msg := range c.pubsub.Channel() {
+		var inv invalidation
+		if err := json.Unmarshal([]byte(msg.Payload), &inv); err != nil {
+			log.Printf("cache:无法解析失效消息 %v\n", err)
+			continue
+		}
+		if inv.Source == c.id {
+			continue
+		}
+		for _, key := range inv.Keys {
+			_ = c.L1.Delete(context.Background(), key)
+		}
+	}
+}
+
+func (c *MultiLevelCache) Get(ctx context.Context, key string) (any, error) {
+	val, err := c.L1.Get(ctx, key)
+	if err == nil {
+		return val, nil
+	}
+	val, err = c.L2.Get(ctx, key)
+	if err == nil {
+		_ = c.L1.Set(ctx, key, val, c.L1Expiration)
+		return val, nil
+	}
+	if !IsKeyNotFound(err) || c.LoadFunc == nil {
+		return nil, err
+	}
+	val, err = c.LoadFunc(ctx, key)
+	if err != nil {
+		//包一层错误信息 方便定位
+		return nil, errs.NewKeyError(key, ErrLoadFailed, err)
+	}
+	//回填失败不影响这次读
+	_ = c.L2.Set(ctx, key, val, c.Expiration)
+	_ = c.L1.Set(ctx, key, val, c.L1Expiration)
+	return val, nil
+}
+
+// Set 先写 L2 再写 L1，然后通知别的实例删掉 L1
+func (c *MultiLevelCache) Set(ctx context.Context, key string, val any, expiration time.Duration) error {
+	if err := c.L2.Set(ctx, key, val, expiration); err != nil {
+		return err
+	}
+	l1Expiration := c.L1Expiration
+	if expiration > 0 && expiration < l1Expiration {
+		l1Expiration = expiration
+	}
+	if err := c.L1.Set(ctx, key, val, l1Expiration); err != nil {
+		return err
+	}
+	return c.publish(ctx, key)
+}
+
+func (c *MultiLevelCache) Delete(ctx context.Context, key string) error {
+	if err := c.L2.Delete(ctx, key); err != nil {
+		return err
+	}
+	if err := c.L1.Delete(ctx, key); err != nil {
+		return err
+	}
+	return c.publish(ctx, key)
+}
+
+func (c *MultiLevelCache) publish(ctx context.Context, keys ...string) error {
+	msg, err := json.Marshal(invalidation{Source: c.id, Keys: keys})
+	if err != nil {
+		return err
+	}
+	return c.client.Publish(ctx, c.channel, msg).Err()
+}
+
+// Close 取消订阅，不会关闭 L1、L2 和 client
+func (c *MultiLevelCache) Close() error {
+	var err error
+	c.once.Do(func() {
+		err = c.pubsub.Close()
+		<-c.done
+	})
+	return err
+}
@@ -0,0 +1,76 @@
+package cache
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// TestMultiLevelCache_Invalidation 两个实例共用一个 redis，A 写了之后 B 的 L1 要被删掉
+func TestMultiLevelCache_Invalidation(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+	newInstance := func() (*MultiLevelCache, *BulidinMapCache) {
+		client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		l1 := NewBuildinMapCache()
+		c, err := NewMultiLevelCache(ctx, l1, NewRedisCache(client), client,
+			MultiLevelCacheWithExpiration(time.Minute, time.Minute))
+		require.NoError(t, err)
+		return c, l1
+	}
+	a, _ := newInstance()
+	defer a.Close()
+	b, bl1 := newInstance()
+	defer b.Close()
+
+	require.NoError(t, a.Set(ctx, "key1", "v1", time.Minute))
+	val, err := b.Get(ctx, "key1")
+	require.NoError(t, err)
+	assert.Equal(t, "v1", val)
+	// B 从 L2 回填了 L1
+	val, err = bl1.Get(ctx, "key1")
+	require.NoError(t, err)
+	assert.Equal(t, "v1", val)
+
+	require.NoError(t, a.Set(ctx, "key1", "v2", time.Minute))
+	assert.Eventually(t, func() bool {
+		_, err := bl1.Get(ctx, "key1")
+		return IsKeyNotFound(err)
+	}, time.Second, time.Millisecond*10)
+	val, err = b.Get(ctx, "key1")
+	require.NoError(t, err)
+	assert.Equal(t, "v2", val)
+
+	require.NoError(t, a.Delete(ctx, "key1"))
+	assert.Eventually(t, func() bool {
+		_, err := b.Get(ctx, "key1")
+		return IsKeyNotFound(err)
+	}, time.Second, time.Millisecond*10)
+}
+
+func TestMultiLevelCache_LoadFunc(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	cnt := 0
+	c, err := NewMultiLevelCache(ctx, NewBuildinMapCache(), NewRedisCache(client), client,
+		MultiLevelCacheWithLoadFunc(func(ctx context.Context, key string) (any, error) {
+			cnt++
+			return "loaded", nil
+		}))
+	require.NoError(t, err)
+	defer c.Close()
+	for i := 0; i < 3; i++ {
+		val, err := c.Get(ctx, "key1")
+		require.NoError(t, err)
+		assert.Equal(t, "loaded", val)
+	}
+	assert.Equal(t, 1, cnt)
+	val, err := mr.Get("key1")
+	require.NoError(t, err)
+	assert.Equal(t, "loaded", val)
+}
@@ -0,0 +1,249 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// NamespaceCache 给key加上命名空间和版本号前缀，实际的key是 {namespace}:v{version}:{key}。
+// 版本号存在底层缓存的 {namespace}:version 里，多个实例共享。InvalidateNamespace 把版本号加一，
+// 旧版本的key就再也访问不到了，不用扫描删除，等它们自己过期。
+// 所以命名空间里的key一定要设置过期时间，不然旧数据会一直占着空间。
+// 版本号不存在（第一次用、过期了、被淘汰了）的时候用当前的纳秒时间当新版本号，不会退回用过的版本号，相当于失效了一次。
+// 底层缓存实现了 AtomicCache 的话初始化用 GetOrSet，先写的说了算，加一用 CompareAndSwap，并发失效不会丢。
+// 版本号存成十进制字符串，经过 RedisCache 的 codec 也能原样读回来，所以不用 IncrBy
+type NamespaceCache struct {
+	Cache
+	namespace  string
+	versionKey string
+	//versionExpiration 版本号本身的过期时间，0表示不过期。LocalCache 不支持不过期，0会换成 foreverExpiration
+	versionExpiration time.Duration
+	//versionTTL 大于0的时候在本地缓存版本号，少一次网络往返，代价是别的实例失效命名空间之后最多 versionTTL 才能看到
+	versionTTL time.Duration
+	mutex      sync.Mutex
+	version    int64
+	fetchedAt  time.Time
+}
+
+type NamespaceCacheOption func(c *NamespaceCache)
+
+// NamespaceWithVersionTTL 在本地缓存版本号 ttl 这么久
+func NamespaceWithVersionTTL(ttl time.Duration) NamespaceCacheOption {
+	return func(c *NamespaceCache) {
+		c.versionTTL = ttl
+	}
+}
+
+// NamespaceWithVersionExpiration 版本号的过期时间，默认不过期
+func NamespaceWithVersionExpiration(expiration time.Duration) NamespaceCacheOption {
+	return func(c *NamespaceCache) {
+		c.versionExpiration = expiration
+	}
+}
+
+func NewNamespaceCache(cache Cache, namespace string, opts ...NamespaceCacheOption) *NamespaceCache {
+	res := &NamespaceCache{
+		Cache:      cache,
+		namespace:  namespace,
+		versionKey: namespace + ":version",
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	if _, ok := cache.(*LocalCache); ok && res.versionExpiration <= 0 {
+		res.versionExpiration = foreverExpiration
+	}
+	return res
+}
+
+// foreverExpiration 给不支持永不过期的缓存用的过期时间
+const foreverExpiration = time.Hour * 24 * 365 * 100
+
+func (c *NamespaceCache) Get(ctx context.Context, key string) (any, error) {
+	prefix, err := c.prefix(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.Cache.Get(ctx, prefix+key)
+}
+
+func (c *NamespaceCache) Set(ctx context.Context, key string, val any, expiration time.Duration) error {
+	prefix, err := c.prefix(ctx)
+	if err != nil {
+		return err
+	}
+	return c.Cache.Set(ctx, prefix+key, val, expiration)
+}
+
+func (c *NamespaceCache) Delete(ctx context.Context, key string) error {
+	prefix, err := c.prefix(ctx)
+	if err != nil {
+		return err
+	}
+	return c.Cache.Delete(ctx, prefix+key)
+}
+
+// GetMulti 返回的key是去掉前缀之后的
+func (c *NamespaceCache) GetMulti(ctx context.Context, keys []string) (map[string]any, error) {
+	prefix, err := c.prefix(ctx)
+	if err != nil {
+		return nil, err
+	}
+	prefixed := make([]string, 0, len(keys))
+	for _, key := range keys {
+		prefixed = append(prefixed, prefix+key)
+	}
+	vals, err := GetMulti(ctx, c.Cache, prefixed)
+	res := make(map[string]any, len(vals))
+	for key, val := range vals {
+		res[key[len(prefix):]] = val
+	}
+	return res, err
+}
+
+func (c *NamespaceCache) SetMulti(ctx context.Context, entries map[string]any, expiration time.Duration) error {
+	prefix, err := c.prefix(ctx)
+	if err != nil {
+		return err
+	}
+	prefixed := make(map[string]any, len(entries))
+	for key, val := range entries {
+		prefixed[prefix+key] = val
+	}
+	return SetMulti(ctx, c.Cache, prefixed, expiration)
+}
+
+func (c *NamespaceCache) DeleteMulti(ctx context.Context, keys ...string) error {
+	prefix, err := c.prefix(ctx)
+	if err != nil {
+		return err
+	}
+	prefixed := make([]string, 0, len(keys))
+	for _, key := range keys {
+		prefixed = append(prefixed, prefix+key)
+	}
+	return DeleteMulti(ctx, c.Cache, prefixed...)
+}
+
+// InvalidateNamespace 版本号加一，命名空间里现有的key全部失效。
+// 底层缓存不是 AtomicCache 的时候读改写不是原子的，两个实例同时失效的话版本号可能只加了一次，但是旧的key一样都失效了
+func (c *NamespaceCache) InvalidateNamespace(ctx context.Context) error {
+	var (
+		version int64
+		err     error
+	)
+	if ac, ok := c.Cache.(AtomicCache); ok {
+		version, err = c.incrVersion(ctx, ac)
+	} else {
+		version, err = c.loadVersion(ctx)
+		if err == nil {
+			version++
+			err = c.Cache.Set(ctx, c.versionKey, strconv.FormatInt(version, 10), c.versionExpiration)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	c.version, c.fetchedAt = version, time.Now()
+	c.mutex.Unlock()
+	return nil
+}
+
+// Version 当前的版本号，第一次用的时候是当时的纳秒时间
+func (c *NamespaceCache) Version(ctx context.Context) (int64, error) {
+	if c.versionTTL > 0 {
+		c.mutex.Lock()
+		version, fetchedAt := c.version, c.fetchedAt
+		c.mutex.Unlock()
+		if !fetchedAt.IsZero() && time.Since(fetchedAt) < c.versionTTL {
+			return version, nil
+		}
+	}
+	version, err := c.loadVersion(ctx)
+	if err != nil {
+		return 0, err
+	}
+	c.mutex.Lock()
+	c.version, c.fetchedAt = version, time.Now()
+	c.mutex.Unlock()
+	return version, nil
+}
+
+func (c *NamespaceCache) prefix(ctx context.Context) (string, error) {
+	version, err := c.Version(ctx)
+	if err != nil {
+		return "", err
+	}
+	return c.namespace + ":v" + strconv.FormatInt(version, 10) + ":", nil
+}
+
+// loadVersion 版本号不存在的时候用当前的纳秒时间，比以前用过的版本号都大。
+// AtomicCache 用 GetOrSet 写，几个实例同时初始化的话先写的说了算，后面的拿到的是它的值；
+// 别的缓存直接 Set，后写的覆盖先写的，先写的实例这一次写的数据读不到，只是多一次没命中
+func (c *NamespaceCache) loadVersion(ctx context.Context) (int64, error) {
+	val, err := c.Cache.Get(ctx, c.versionKey)
+	if IsKeyNotFound(err) {
+		seed := strconv.FormatInt(time.Now().UnixNano(), 10)
+		if ac, ok := c.Cache.(AtomicCache); ok {
+			val, _, err = ac.GetOrSet(ctx, c.versionKey, seed, c.versionExpiration)
+		} else {
+			val, err = seed, c.Cache.Set(ctx, c.versionKey, seed, c.versionExpiration)
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	return parseVersion(val)
+}
+
+// incrVersion 读出来加一再 CompareAndSwap 回去，中间被别人改了就重来
+func (c *NamespaceCache) incrVersion(ctx context.Context, ac AtomicCache) (int64, error) {
+	for {
+		val, ver, err := ac.GetWithVersion(ctx, c.versionKey)
+		if IsKeyNotFound(err) {
+			//不存在的时候先初始化，新的种子本身就是一个没用过的版本号
+			if _, err = c.loadVersion(ctx); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		version, err := parseVersion(val)
+		if err != nil {
+			return 0, err
+		}
+		version++
+		ok, err := ac.CompareAndSwap(ctx, c.versionKey, ver, strconv.FormatInt(version, 10), c.versionExpiration)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			return version, nil
+		}
+	}
+}
+
+// parseVersion 不同的底层缓存读回来的类型不一样，现在写的是 string，本地缓存的老数据是 int64，
+// RedisCache 的老数据用 JSON 是 float64
+func parseVersion(val any) (int64, error) {
+	switch v := val.(type) {
+	case int64:
+		return v, nil
+	case int:
+		return int64(v), nil
+	case float64:
+		return int64(v), nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	case []byte:
+		return strconv.ParseInt(string(v), 10, 64)
+	default:
+		return 0, fmt.Errorf("%w: 命名空间版本号 %T", ErrCacheValueType, val)
+	}
+}
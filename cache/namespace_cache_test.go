@@ -0,0 +1,201 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNamespaceCache_InvalidateNamespace(t *testing.T) {
+	mr := miniredis.RunT(t)
+	testCases := []struct {
+		name  string
+		cache func() Cache
+	}{
+		{
+			name:  "buildin map",
+			cache: func() Cache { return NewBuildinMapCache() },
+		},
+		{
+			name:  "redis",
+			cache: func() Cache { return NewRedisCache(redis.NewClient(&redis.Options{Addr: mr.Addr()})) },
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mr.FlushAll()
+			ctx := context.Background()
+			inner := tc.cache()
+			tenant := NewNamespaceCache(inner, "tenant:1")
+			product := NewNamespaceCache(inner, "product")
+			require.NoError(t, tenant.SetMulti(ctx, map[string]any{"key1": "v1", "key2": "v2"}, time.Minute))
+			require.NoError(t, product.Set(ctx, "key1", "p1", time.Minute))
+			vals, err := tenant.GetMulti(ctx, []string{"key1", "key2", "key3"})
+			require.NoError(t, err)
+			assert.Equal(t, map[string]any{"key1": "v1", "key2": "v2"}, vals)
+			v0, err := tenant.Version(ctx)
+			require.NoError(t, err)
+			val, err := inner.Get(ctx, fmt.Sprintf("tenant:1:v%d:key1", v0))
+			require.NoError(t, err)
+			assert.Equal(t, "v1", val)
+
+			require.NoError(t, tenant.InvalidateNamespace(ctx))
+			version, err := tenant.Version(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, v0+1, version)
+			_, err = tenant.Get(ctx, "key1")
+			assert.True(t, IsKeyNotFound(err))
+			// 别的命名空间不受影响
+			val, err = product.Get(ctx, "key1")
+			require.NoError(t, err)
+			assert.Equal(t, "p1", val)
+
+			require.NoError(t, tenant.Set(ctx, "key1", "new", time.Minute))
+			val, err = tenant.Get(ctx, "key1")
+			require.NoError(t, err)
+			assert.Equal(t, "new", val)
+			// 旧的key没有删，等它自己过期
+			_, err = inner.Get(ctx, fmt.Sprintf("tenant:1:v%d:key2", v0))
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestNamespaceCache_VersionTTL(t *testing.T) {
+	ctx := context.Background()
+	inner := NewBuildinMapCache()
+	a := NewNamespaceCache(inner, "ns", NamespaceWithVersionTTL(time.Millisecond*100))
+	b := NewNamespaceCache(inner, "ns")
+	require.NoError(t, a.Set(ctx, "key1", "v1", time.Minute))
+	require.NoError(t, b.InvalidateNamespace(ctx))
+	// a 还在用本地缓存的版本号
+	val, err := a.Get(ctx, "key1")
+	require.NoError(t, err)
+	assert.Equal(t, "v1", val)
+	assert.Eventually(t, func() bool {
+		_, err := a.Get(ctx, "key1")
+		return IsKeyNotFound(err)
+	}, time.Second, time.Millisecond*10)
+}
+
+func TestNamespaceCache_VersionEvicted(t *testing.T) {
+	testCases := []struct {
+		name  string
+		cache func() Cache
+	}{
+		{
+			name:  "atomic",
+			cache: func() Cache { return NewBuildinMapCache() },
+		},
+		{
+			// LocalCache 不是 AtomicCache，版本号默认不能马上过期
+			name:  "local",
+			cache: func() Cache { return NewLocalCache(nil) },
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			inner := tc.cache()
+			ns := NewNamespaceCache(inner, "ns")
+			require.NoError(t, ns.Set(ctx, "key1", "v1", time.Minute))
+			require.NoError(t, ns.InvalidateNamespace(ctx))
+			require.NoError(t, ns.Set(ctx, "key1", "v2", time.Minute))
+			val, err := ns.Get(ctx, "key1")
+			require.NoError(t, err)
+			assert.Equal(t, "v2", val)
+
+			// 版本号被淘汰了，不能退回用过的版本号，失效过的数据不能再读到
+			require.NoError(t, inner.Delete(ctx, "ns:version"))
+			_, err = ns.Get(ctx, "key1")
+			assert.True(t, IsKeyNotFound(err))
+			require.NoError(t, ns.InvalidateNamespace(ctx))
+			_, err = ns.Get(ctx, "key1")
+			assert.True(t, IsKeyNotFound(err))
+		})
+	}
+}
+
+func TestNamespaceCache_ConcurrentInvalidate(t *testing.T) {
+	ctx := context.Background()
+	inner := NewBuildinMapCache()
+	v0, err := NewNamespaceCache(inner, "ns").Version(ctx)
+	require.NoError(t, err)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, NewNamespaceCache(inner, "ns").InvalidateNamespace(ctx))
+		}()
+	}
+	wg.Wait()
+	version, err := NewNamespaceCache(inner, "ns").Version(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, v0+50, version)
+}
+
+func TestNamespaceCache_ConcurrentSeed(t *testing.T) {
+	mr := miniredis.RunT(t)
+	testCases := []struct {
+		name  string
+		cache func() Cache
+	}{
+		{
+			name:  "buildin map",
+			cache: func() Cache { return NewBuildinMapCache() },
+		},
+		{
+			name:  "redis",
+			cache: func() Cache { return NewRedisCache(redis.NewClient(&redis.Options{Addr: mr.Addr()})) },
+		},
+		{
+			// 版本号经过 codec 也要能原样读回来
+			name: "redis json",
+			cache: func() Cache {
+				return NewRedisCache(redis.NewClient(&redis.Options{Addr: mr.Addr()}), RedisCacheWithCodec(JSONCodec{}))
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mr.FlushAll()
+			ctx := context.Background()
+			inner := tc.cache()
+			// 很多实例同时初始化，先写的说了算，大家拿到的是同一个版本号
+			versions := make([]int64, 20)
+			var wg sync.WaitGroup
+			for i := range versions {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					version, err := NewNamespaceCache(inner, "ns").Version(ctx)
+					assert.NoError(t, err)
+					versions[i] = version
+				}(i)
+			}
+			wg.Wait()
+			version, err := NewNamespaceCache(inner, "ns").Version(ctx)
+			require.NoError(t, err)
+			assert.Greater(t, version, int64(0))
+			for _, v := range versions {
+				assert.Equal(t, version, v)
+			}
+
+			ns := NewNamespaceCache(inner, "ns")
+			require.NoError(t, ns.Set(ctx, "key1", "v1", time.Minute))
+			require.NoError(t, ns.InvalidateNamespace(ctx))
+			after, err := ns.Version(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, version+1, after)
+			_, err = ns.Get(ctx, "key1")
+			assert.True(t, IsKeyNotFound(err))
+		})
+	}
+}
@@ -16,22 +16,18 @@ type PreloadCache struct {
 }
 
 func NewPreloadCache(expiration time.Duration, onEvicted func(key string, val any), loadFunc LoadFunc) *PreloadCache {
-	c := ReadThroughCache{NewLocalCache(onEvicted), expiration, loadFunc}
-	return &PreloadCache{
+	res := &PreloadCache{
 		expiration:       expiration,
-		ReadThroughCache: c,
-		SentinelCache: NewLocalCache(func(key string, val any) {
-			res, err := loadFunc(context.Background(), key)
-			if err != nil {
-				log.Fatalf("cache:sentinel预加载失败%v\n", err)
-				return
-			}
-			err = c.Set(context.Background(), key, res, expiration)
-			if err != nil {
-				log.Fatalf("cache:主cache预存失败%v\n", err)
-			}
-		}),
+		ReadThroughCache: *NewReadThroughCache(NewLocalCache(onEvicted), expiration, loadFunc),
 	}
+	res.SentinelCache = NewLocalCache(func(key string, val any) {
+		//走 load 回源，预加载也会算进回源的统计里
+		_, err := res.load(context.Background(), key)
+		if err != nil {
+			log.Printf("cache:sentinel预加载失败%v\n", err)
+		}
+	})
+	return res
 }
 
 func (c *PreloadCache) Set(ctx context.Context, key string, val any, expiration time.Duration) error {
@@ -1,10 +1,15 @@
 package cache
 
 import (
+	"bytes"
 	"context"
+	"encoding/gob"
+	"errors"
 	"fmt"
 	"github.com/xuhaidong1/go-generic-tools/cache/errs"
-	"log"
+	"golang.org/x/sync/singleflight"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -13,39 +18,376 @@ type ReadThroughCache struct {
 	Expiration time.Duration
 	//把捞DB抽象为“加载数据”
 	LoadFunc
+	//NegativeExpiration 大于0的时候开启负缓存：LoadFunc 说数据不存在的时候缓存一个哨兵值，
+	//过期之前再来读直接返回 ErrDataNotFound，不会再打到DB。一般比 Expiration 短很多
+	NegativeExpiration time.Duration
+	//IsNotFound 判断 LoadFunc 的错误是不是"数据不存在"，返回false的当作临时错误，不会缓存
+	IsNotFound func(err error) bool
+
+	//softExpiration 大于0的时候开启 stale-while-revalidate，Expiration 是硬过期时间
+	softExpiration time.Duration
+	refreshGroup   *singleflight.Group
+	onError        func(ctx context.Context, key string, err error)
+	recorder       StatsRecorder
+	batchLoad      BatchLoadFunc
+	//wrapper 不为nil的时候写缓存之前用它包一层，XFetchCache 用来记回源时间
+	wrapper func(val any, loadTime time.Duration) any
+}
+
+type ReadThroughCacheOption func(c *ReadThroughCache)
+
+// WithNegativeCache 开启负缓存，isNotFound 为nil的时候只认 ErrDataNotFound，
+// 比如用 gorm 可以传 func(err error) bool { return errors.Is(err, gorm.ErrRecordNotFound) }
+func WithNegativeCache(expiration time.Duration, isNotFound func(err error) bool) ReadThroughCacheOption {
+	return func(c *ReadThroughCache) {
+		c.NegativeExpiration = expiration
+		c.IsNotFound = isNotFound
+	}
+}
+
+// WithStaleWhileRevalidate 开启 stale-while-revalidate：写缓存的时候记下软过期时间，
+// 过了软过期时间、还没到硬过期时间（Expiration）的时候，Get 直接返回旧值，同时在后台刷新一次，
+// 同一个key同时只会有一个刷新；过了硬过期时间缓存里就没有了，只能阻塞回源。
+// 缓存里存的是包了一层的值，本地缓存、RedisCache 的 JSONCodec、GobAnyCodec 都能读回来，
+// 没有设置 codec 的 RedisCache 只能存字符串、[]byte、数字这些 go-redis 自己会写的值
+func WithStaleWhileRevalidate(softExpiration time.Duration) ReadThroughCacheOption {
+	return func(c *ReadThroughCache) {
+		c.softExpiration = softExpiration
+		c.refreshGroup = &singleflight.Group{}
+	}
+}
+
+// WithOnLoadError 后台刷新失败、回源之后写缓存失败的时候回调，这些错误不会返回给调用方，默认忽略
+func WithOnLoadError(fn func(ctx context.Context, key string, err error)) ReadThroughCacheOption {
+	return func(c *ReadThroughCache) {
+		c.onError = fn
+	}
+}
+
+// WithBatchLoadFunc GetMulti 没命中的key一次性回源，不设置的话就一个一个用 LoadFunc 加载
+func WithBatchLoadFunc(fn BatchLoadFunc) ReadThroughCacheOption {
+	return func(c *ReadThroughCache) {
+		c.batchLoad = fn
+	}
 }
 
-func NewReadThroughCache(cache Cache, Expiration time.Duration, loadFunc LoadFunc) *ReadThroughCache {
-	return &ReadThroughCache{
+// ReadThroughWithStatsRecorder 替换默认的统计埋点，命中、回源都记在这里
+func ReadThroughWithStatsRecorder(recorder StatsRecorder) ReadThroughCacheOption {
+	return func(c *ReadThroughCache) {
+		c.recorder = recorder
+	}
+}
+
+func NewReadThroughCache(cache Cache, Expiration time.Duration, loadFunc LoadFunc, opts ...ReadThroughCacheOption) *ReadThroughCache {
+	res := &ReadThroughCache{
 		Cache:      cache,
 		Expiration: Expiration,
 		LoadFunc:   loadFunc,
+		recorder:   NewStatsRecorder(),
+	}
+	for _, opt := range opts {
+		opt(res)
 	}
+	return res
 }
 
+// negativeSentinel 负缓存的哨兵值，用字符串是为了 RedisCache 这种会序列化的实现也能原样读回来
+const negativeSentinel = "\x00cache:not_found\x00"
+
 // Get 加锁问题：先穿透读 再有人写数据库，数据就会不一致；加了写锁也会有不一致，保证了读，但直接写cache就会不一致
 func (c *ReadThroughCache) Get(ctx context.Context, key string) (any, error) {
-	//先捞缓存 再捞db
+	return c.get(ctx, key, c.load)
+}
+
+// get 先捞缓存，没命中再用 load 捞db。装饰器通过替换 load 来控制回源的方式
+func (c *ReadThroughCache) get(ctx context.Context, key string, load LoadFunc) (any, error) {
 	val, err := c.Cache.Get(ctx, key)
+	if err == nil {
+		c.statsRecorder().RecordHits(1)
+		return c.unwrap(key, val)
+	}
 	//不知道哪里出问题了
-	if err != nil && err != errs.NewErrKeyNotFound(key) {
+	if !IsKeyNotFound(err) {
 		return nil, err
 	}
-	if err == errs.NewErrKeyNotFound(key) {
+	c.statsRecorder().RecordMisses(1)
+	return load(ctx, key)
+}
+
+// unwrap 处理负缓存和软过期
+func (c *ReadThroughCache) unwrap(key string, val any) (any, error) {
+	if isNegative(val) {
+		return nil, errs.NewKeyError(key, ErrDataNotFound, nil)
+	}
+	itm, ok := decodeStaleItem(val)
+	if !ok {
+		return val, nil
+	}
+	if time.Now().After(itm.SoftDeadline) {
+		c.refresh(key)
+	}
+	return itm.Val, nil
+}
 
-		val, err = c.LoadFunc(ctx, key)
+// refresh 后台刷新，同一个key正在刷新的时候不会重复刷新
+func (c *ReadThroughCache) refresh(key string) {
+	c.refreshGroup.DoChan(key, func() (any, error) {
+		//请求的ctx可能马上就取消了，后台刷新不能用它
+		ctx := context.Background()
+		val, err := c.load(ctx, key)
 		if err != nil {
-			//包一层错误信息 方便定位
-			return nil, fmt.Errorf("cache:无法加载数据 %w", err)
+			c.handleErr(ctx, key, err)
+		}
+		return val, err
+	})
+}
+
+// load 回源并写缓存
+func (c *ReadThroughCache) load(ctx context.Context, key string) (any, error) {
+	start := time.Now()
+	val, err := c.LoadFunc(ctx, key)
+	c.statsRecorder().RecordLoad(time.Since(start), err)
+	if err != nil {
+		return nil, c.loadErr(ctx, key, err)
+	}
+	c.store(ctx, key, val, time.Since(start))
+	return val, nil
+}
+
+// store 回源之后写缓存
+func (c *ReadThroughCache) store(ctx context.Context, key string, val any, loadTime time.Duration) {
+	//这里err可以考虑忽略掉，写失败无非下次再回源
+	if err := c.Cache.Set(ctx, key, c.wrap(val, loadTime), c.Expiration); err != nil {
+		c.handleErr(ctx, key, err)
+	}
+}
+
+// wrap 开启了 stale-while-revalidate 的时候包一层软过期时间
+func (c *ReadThroughCache) wrap(val any, loadTime time.Duration) any {
+	if c.wrapper != nil {
+		return c.wrapper(val, loadTime)
+	}
+	if c.softExpiration > 0 {
+		return &staleItem{Val: val, SoftDeadline: time.Now().Add(c.softExpiration)}
+	}
+	return val
+}
+
+// GetMulti 先批量捞缓存，没命中的再回源，结果里只有找到了的key。
+// 设置了 BatchLoadFunc 的时候没命中的key一次性加载，加载到的批量写回缓存
+func (c *ReadThroughCache) GetMulti(ctx context.Context, keys []string) (map[string]any, error) {
+	return c.getMulti(ctx, keys, nil)
+}
+
+// getMulti loadable 不为nil的时候只有它返回true的key才回源
+func (c *ReadThroughCache) getMulti(ctx context.Context, keys []string, loadable BloomFilter) (map[string]any, error) {
+	cached, err := GetMulti(ctx, c.Cache, keys)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]any, len(keys))
+	var missing []string
+	for _, key := range keys {
+		val, ok := cached[key]
+		if !ok {
+			missing = append(missing, key)
+			continue
+		}
+		//负缓存命中的不放在结果里
+		if val, err = c.unwrap(key, val); err == nil {
+			res[key] = val
+		}
+	}
+	c.statsRecorder().RecordHits(len(keys) - len(missing))
+	c.statsRecorder().RecordMisses(len(missing))
+	if loadable != nil {
+		toLoad := missing[:0]
+		for _, key := range missing {
+			if loadable(ctx, key) {
+				toLoad = append(toLoad, key)
+			}
+		}
+		missing = toLoad
+	}
+	if len(missing) == 0 {
+		return res, nil
+	}
+	if c.batchLoad != nil {
+		return res, c.loadMulti(ctx, missing, res)
+	}
+	for _, key := range missing {
+		val, err := c.load(ctx, key)
+		if err == nil {
+			res[key] = val
+			continue
 		}
+		if !c.isNotFound(err) {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// loadMulti 批量回源，加载到的放进 res 并写回缓存，没加载到的开启了负缓存就写哨兵
+func (c *ReadThroughCache) loadMulti(ctx context.Context, keys []string, res map[string]any) error {
+	start := time.Now()
+	vals, err := c.batchLoad(ctx, keys)
+	loadTime := time.Since(start)
+	c.statsRecorder().RecordLoad(loadTime, err)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrLoadFailed, err)
+	}
+	toStore := make(map[string]any, len(vals))
+	negatives := make(map[string]any)
+	for _, key := range keys {
+		val, ok := vals[key]
+		if ok {
+			res[key] = val
+			//一批一起加载的，每个key都按整批的时间算
+			toStore[key] = c.wrap(val, loadTime)
+		} else if c.NegativeExpiration > 0 {
+			negatives[key] = negativeSentinel
+		}
+	}
+	//写失败无非下次再回源
+	if err = SetMulti(ctx, c.Cache, toStore, c.Expiration); err != nil {
+		for key := range toStore {
+			c.handleErr(ctx, key, err)
+		}
+	}
+	if len(negatives) > 0 {
+		_ = SetMulti(ctx, c.Cache, negatives, c.NegativeExpiration)
+	}
+	return nil
+}
+
+// SetMulti 直接写底层缓存，和 Set 一样
+func (c *ReadThroughCache) SetMulti(ctx context.Context, entries map[string]any, expiration time.Duration) error {
+	return SetMulti(ctx, c.Cache, entries, expiration)
+}
+
+func (c *ReadThroughCache) DeleteMulti(ctx context.Context, keys ...string) error {
+	return DeleteMulti(ctx, c.Cache, keys...)
+}
+
+func (c *ReadThroughCache) handleErr(ctx context.Context, key string, err error) {
+	if c.onError != nil {
+		c.onError(ctx, key, err)
+	}
+}
+
+// loadErr 处理 LoadFunc 的错误，数据不存在的时候写负缓存
+func (c *ReadThroughCache) loadErr(ctx context.Context, key string, err error) error {
+	if c.NegativeExpiration <= 0 || !c.isNotFound(err) {
+		//包一层错误信息 方便定位，errors.Is 对 ErrLoadFailed 和原来的错误都能匹配
+		return errs.NewKeyError(key, ErrLoadFailed, err)
+	}
+	//负缓存写失败了无非下次再查一次库
+	_ = c.Cache.Set(ctx, key, negativeSentinel, c.NegativeExpiration)
+	return errs.NewKeyError(key, ErrDataNotFound, err)
+}
+
+func (c *ReadThroughCache) isNotFound(err error) bool {
+	if c.IsNotFound != nil {
+		return c.IsNotFound(err)
+	}
+	return errors.Is(err, ErrDataNotFound)
+}
+
+// Stats 命中率是这一层看到的，淘汰和key的数量来自底层缓存
+func (c *ReadThroughCache) Stats() Stats {
+	return mergeStats(c.statsRecorder().Snapshot(), c.Cache)
+}
+
+// statsRecorder 直接用结构体字面量创建的没有 recorder
+func (c *ReadThroughCache) statsRecorder() StatsRecorder {
+	return orNoopRecorder(c.recorder)
+}
+
+func init() {
+	gob.Register(&staleItem{})
+}
+
+// staleItem 开启 stale-while-revalidate 的时候缓存里实际存的值。
+// 底层缓存不一样，读回来的样子也不一样，decodeStaleItem 都要认识：
+// 本地缓存和 GobAnyCodec 原样读回来；JSONCodec 读回来是 map[string]any，靠 json tag 认出来；
+// 没有 codec 的 RedisCache 由 go-redis 调 MarshalBinary 写成带前缀的字符串
+type staleItem struct {
+	Val          any       `json:"__stale_val"`
+	SoftDeadline time.Time `json:"__stale_soft_deadline"`
+}
+
+// staleItemPrefix 没有 codec 的时候 staleItem 写成 前缀+软过期时间的纳秒数+:+值
+const staleItemPrefix = "\x00cache:stale\x00"
+
+// MarshalBinary 给 go-redis 用的，值按 go-redis 写参数的方式转成字符串，读回来也是字符串
+func (s *staleItem) MarshalBinary() ([]byte, error) {
+	val, err := redisArgString(s.Val)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(staleItemPrefix + strconv.FormatInt(s.SoftDeadline.UnixNano(), 10) + ":" + val), nil
+}
 
-		err = c.Set(ctx, key, val, c.Expiration)
-		//这里err可以考虑忽略掉
+// staleItemGob gob 会优先用 MarshalBinary，GobEncode 换成一个没有方法的类型按字段编码
+type staleItemGob struct {
+	Val          any
+	SoftDeadline time.Time
+}
+
+func (s *staleItem) GobEncode() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(staleItemGob(*s)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (s *staleItem) GobDecode(data []byte) error {
+	var itm staleItemGob
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&itm); err != nil {
+		return err
+	}
+	*s = staleItem(itm)
+	return nil
+}
+
+// decodeStaleItem 认出各种底层缓存读回来的 staleItem
+func decodeStaleItem(val any) (*staleItem, bool) {
+	switch v := val.(type) {
+	case *staleItem:
+		return v, true
+	case map[string]any:
+		raw, ok := v["__stale_val"]
+		deadline, ok2 := v["__stale_soft_deadline"].(string)
+		if !ok || !ok2 || len(v) != 2 {
+			return nil, false
+		}
+		t, err := time.Parse(time.RFC3339Nano, deadline)
 		if err != nil {
-			log.Fatalln(err)
+			return nil, false
 		}
-		return val, nil
+		return &staleItem{Val: raw, SoftDeadline: t}, true
+	case string:
+		rest, ok := strings.CutPrefix(v, staleItemPrefix)
+		if !ok {
+			return nil, false
+		}
+		nanos, val, ok := strings.Cut(rest, ":")
+		if !ok {
+			return nil, false
+		}
+		n, err := strconv.ParseInt(nanos, 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		return &staleItem{Val: val, SoftDeadline: time.Unix(0, n)}, true
+	default:
+		return nil, false
 	}
-	return val, nil
+}
 
+func isNegative(val any) bool {
+	s, ok := val.(string)
+	return ok && s == negativeSentinel
 }
@@ -4,9 +4,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"gorm.io/gorm"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -28,3 +33,173 @@ func TestReadThroughCache_Get(t *testing.T) {
 	})
 	fmt.Println(UserCache)
 }
+
+func TestReadThroughCache_NegativeCache(t *testing.T) {
+	errNoRow := errors.New("record not found")
+	errTimeout := errors.New("db timeout")
+	testCases := []struct {
+		name     string
+		loadErr  error
+		wantErr  error
+		wantLoad int
+	}{
+		{
+			name:     "not found cached",
+			loadErr:  errNoRow,
+			wantErr:  ErrDataNotFound,
+			wantLoad: 1,
+		},
+		{
+			name:     "transient error not cached",
+			loadErr:  errTimeout,
+			wantErr:  errTimeout,
+			wantLoad: 3,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			loaded := 0
+			c := NewReadThroughCache(NewBuildinMapCache(), time.Minute, func(ctx context.Context, key string) (any, error) {
+				loaded++
+				return nil, tc.loadErr
+			}, WithNegativeCache(time.Minute, func(err error) bool {
+				return errors.Is(err, errNoRow)
+			}))
+			for i := 0; i < 3; i++ {
+				_, err := c.Get(context.Background(), "/user/1")
+				assert.True(t, errors.Is(err, tc.wantErr))
+			}
+			assert.Equal(t, tc.wantLoad, loaded)
+		})
+	}
+}
+
+func TestReadThroughCache_NegativeCacheExpired(t *testing.T) {
+	loaded := 0
+	c := NewReadThroughCache(NewBuildinMapCache(), time.Minute, func(ctx context.Context, key string) (any, error) {
+		loaded++
+		if loaded == 1 {
+			return nil, ErrDataNotFound
+		}
+		return "user1", nil
+	}, WithNegativeCache(time.Millisecond*100, nil))
+	_, err := c.Get(context.Background(), "/user/1")
+	assert.True(t, errors.Is(err, ErrDataNotFound))
+	_, err = c.Get(context.Background(), "/user/1")
+	assert.ErrorIs(t, err, ErrDataNotFound)
+	time.Sleep(time.Millisecond * 200)
+	val, err := c.Get(context.Background(), "/user/1")
+	require.NoError(t, err)
+	assert.Equal(t, "user1", val)
+	assert.Equal(t, 2, loaded)
+}
+
+func TestReadThroughCache_StaleWhileRevalidate(t *testing.T) {
+	mr := miniredis.RunT(t)
+	var loaded int32
+	release := make(chan struct{})
+	loadFunc := func(ctx context.Context, key string) (any, error) {
+		n := atomic.AddInt32(&loaded, 1)
+		if n > 1 {
+			//后台刷新卡住，模拟慢查询
+			<-release
+		}
+		return "v" + strconv.Itoa(int(n)), nil
+	}
+	testCases := []struct {
+		name  string
+		cache func() Cache
+	}{
+		{
+			name: "read through",
+			cache: func() Cache {
+				return NewReadThroughCache(NewBuildinMapCache(), time.Minute, loadFunc,
+					WithStaleWhileRevalidate(time.Millisecond*100))
+			},
+		},
+		{
+			name: "single flight",
+			cache: func() Cache {
+				return NewSingleFlightCache(NewBuildinMapCache(), time.Minute, loadFunc,
+					WithStaleWhileRevalidate(time.Millisecond*100))
+			},
+		},
+		{
+			name: "redis",
+			cache: func() Cache {
+				return NewReadThroughCache(NewRedisCache(redis.NewClient(&redis.Options{Addr: mr.Addr()})),
+					time.Minute, loadFunc, WithStaleWhileRevalidate(time.Millisecond*100))
+			},
+		},
+		{
+			name: "redis json",
+			cache: func() Cache {
+				return NewSingleFlightCache(NewRedisCache(redis.NewClient(&redis.Options{Addr: mr.Addr()}),
+					RedisCacheWithCodec(JSONCodec{})), time.Minute, loadFunc, WithStaleWhileRevalidate(time.Millisecond*100))
+			},
+		},
+		{
+			name: "redis gob",
+			cache: func() Cache {
+				return NewReadThroughCache(NewRedisCache(redis.NewClient(&redis.Options{Addr: mr.Addr()}),
+					RedisCacheWithCodec(GobAnyCodec{})), time.Minute, loadFunc, WithStaleWhileRevalidate(time.Millisecond*100))
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mr.FlushAll()
+			atomic.StoreInt32(&loaded, 0)
+			release = make(chan struct{})
+			c := tc.cache()
+			ctx := context.Background()
+			val, err := c.Get(ctx, "key1")
+			require.NoError(t, err)
+			assert.Equal(t, "v1", val)
+			time.Sleep(time.Millisecond * 150)
+			// 软过期之后不会阻塞，直接返回旧值，刷新只会触发一次
+			for i := 0; i < 10; i++ {
+				val, err = c.Get(ctx, "key1")
+				require.NoError(t, err)
+				assert.Equal(t, "v1", val)
+			}
+			close(release)
+			assert.Eventually(t, func() bool {
+				val, err := c.Get(ctx, "key1")
+				return err == nil && val == "v2"
+			}, time.Second, time.Millisecond*10)
+			assert.Equal(t, int32(2), atomic.LoadInt32(&loaded))
+		})
+	}
+}
+
+func TestReadThroughCache_OnLoadError(t *testing.T) {
+	loadErr := errors.New("db down")
+	var loaded int32
+	errCh := make(chan error, 1)
+	c := NewReadThroughCache(NewBuildinMapCache(), time.Minute, func(ctx context.Context, key string) (any, error) {
+		if atomic.AddInt32(&loaded, 1) > 1 {
+			return nil, loadErr
+		}
+		return "v1", nil
+	}, WithStaleWhileRevalidate(time.Millisecond*50), WithOnLoadError(func(ctx context.Context, key string, err error) {
+		errCh <- err
+	}))
+	ctx := context.Background()
+	_, err := c.Get(ctx, "key1")
+	require.NoError(t, err)
+	time.Sleep(time.Millisecond * 100)
+	val, err := c.Get(ctx, "key1")
+	require.NoError(t, err)
+	assert.Equal(t, "v1", val)
+	select {
+	case err = <-errCh:
+		assert.True(t, errors.Is(err, loadErr))
+	case <-time.After(time.Second):
+		t.Fatal("没有收到刷新失败的回调")
+	}
+	// 刷新失败了旧值还在
+	val, err = c.Get(ctx, "key1")
+	require.NoError(t, err)
+	assert.Equal(t, "v1", val)
+}
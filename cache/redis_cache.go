@@ -2,29 +2,456 @@ package cache
 
 import (
 	"context"
+	"crypto/sha1"
+	_ "embed"
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"github.com/redis/go-redis/v9"
+	"github.com/xuhaidong1/go-generic-tools/cache/errs"
+	"strconv"
+	"strings"
 	"time"
 )
 
+var (
+	//go:embed lua/set_with_tags.lua
+	luaSetWithTags string
+	//go:embed lua/invalidate_tags.lua
+	luaInvalidateTags string
+	//go:embed lua/get_or_set.lua
+	luaGetOrSet string
+	//go:embed lua/compare_and_swap.lua
+	luaCompareAndSwap string
+)
+
 type RedisCache struct {
-	client redis.Cmdable //cmdable方便使用gomock
+	client   redis.Cmdable //cmdable方便使用gomock
+	recorder StatsRecorder
+
+	codec      Codec
+	compressor Compressor
+	threshold  int
+	decoders   []Codec
+	//vc 为nil的时候和以前一样，值直接交给 go-redis，读出来是 string
+	vc *valueCodec
+	//tagPrefix 标签 set 的key前缀
+	tagPrefix string
+}
+
+type RedisCacheOption func(r *RedisCache)
+
+// RedisCacheWithCodec 值用 codec 序列化，前面加一个头部字节标记 codec 和压缩算法，读的时候按头部解码
+func RedisCacheWithCodec(codec Codec) RedisCacheOption {
+	return func(r *RedisCache) {
+		r.codec = codec
+	}
+}
+
+// RedisCacheWithCompression 序列化之后超过 threshold 字节的压缩，compressor 为nil的时候用 gzip，
+// 没有设置 codec 的时候用 JSONCodec
+func RedisCacheWithCompression(threshold int, compressor Compressor) RedisCacheOption {
+	return func(r *RedisCache) {
+		if compressor == nil {
+			compressor = GzipCompressor{}
+		}
+		r.threshold = threshold
+		r.compressor = compressor
+	}
+}
+
+// RedisCacheWithDecoders 只用来读的 codec，换 codec 的时候把旧的放进来，旧数据还能读。
+// JSON、gob、bytes 三种默认就认识
+func RedisCacheWithDecoders(codecs ...Codec) RedisCacheOption {
+	return func(r *RedisCache) {
+		r.decoders = append(r.decoders, codecs...)
+	}
+}
+
+// RedisCacheWithTagPrefix 标签 set 的key前缀，默认 "tag:"
+func RedisCacheWithTagPrefix(prefix string) RedisCacheOption {
+	return func(r *RedisCache) {
+		r.tagPrefix = prefix
+	}
+}
+
+// RedisCacheWithStatsRecorder 替换默认的统计埋点
+func RedisCacheWithStatsRecorder(recorder StatsRecorder) RedisCacheOption {
+	return func(r *RedisCache) {
+		r.recorder = recorder
+	}
 }
 
 // NewRedisCache 面向接口编程，依赖注入，不要传一个string的地址自己建redisClient，要不然单元测试就会尝试连这个addr，没办法测，我们需要mockredis
-func NewRedisCache(client redis.Cmdable) *RedisCache {
-	return &RedisCache{client: client}
+func NewRedisCache(client redis.Cmdable, opts ...RedisCacheOption) *RedisCache {
+	res := &RedisCache{client: client, recorder: NewStatsRecorder(), tagPrefix: "tag:"}
+	for _, opt := range opts {
+		opt(res)
+	}
+	res.recorder = orNoopRecorder(res.recorder)
+	if res.compressor != nil && res.codec == nil {
+		res.codec = JSONCodec{}
+	}
+	if res.codec != nil {
+		res.vc = newValueCodec(res.codec)
+		res.vc.addCodecs(res.decoders...)
+		res.vc.compressor = res.compressor
+		res.vc.threshold = res.threshold
+		if res.compressor != nil {
+			res.vc.compressors[res.compressor.ID()&0x07] = res.compressor
+		}
+	}
+	return res
 }
 
+// Get 设置了 codec 的时候按头部解码，JSON 解出来的结构体是 map[string]any，需要具体类型用 GetInto
 func (r *RedisCache) Get(ctx context.Context, key string) (any, error) {
-	return r.client.Get(ctx, key).Result()
+	val, err := r.get(ctx, key)
+	if err != nil || r.vc == nil {
+		return val, err
+	}
+	return r.decode(key, val)
+}
+
+// GetInto 解码到 dst 指向的变量，没有设置 codec 的时候 dst 只能是 *string、*[]byte、*any
+func (r *RedisCache) GetInto(ctx context.Context, key string, dst any) error {
+	val, err := r.get(ctx, key)
+	if err != nil {
+		return err
+	}
+	return r.decodeInto(key, val, dst)
+}
+
+func (r *RedisCache) get(ctx context.Context, key string) (string, error) {
+	val, err := r.client.Get(ctx, key).Result()
+	switch {
+	case err == nil:
+		r.recorder.RecordHits(1)
+	case errors.Is(err, redis.Nil):
+		r.recorder.RecordMisses(1)
+		//和别的实现一样返回 ErrCacheKeyNotExist，errors.Is(err, redis.Nil) 也还能用
+		return val, errs.NewKeyError(key, ErrCacheKeyNotExist, err)
+	}
+	return val, err
+}
+
+func (r *RedisCache) decode(key, val string) (any, error) {
+	var res any
+	ok, err := r.vc.decode([]byte(val), &res)
+	if !ok {
+		//头部不认识，是加 codec 之前写的老数据，原样返回
+		return val, nil
+	}
+	if err != nil {
+		return nil, errs.NewKeyError(key, ErrCacheCodec, err)
+	}
+	return res, nil
+}
+
+func (r *RedisCache) decodeInto(key, val string, dst any) error {
+	if r.vc != nil {
+		if ok, err := r.vc.decode([]byte(val), dst); ok {
+			if err != nil {
+				return errs.NewKeyError(key, ErrCacheCodec, err)
+			}
+			return nil
+		}
+	}
+	if err := (BytesCodec{}).Unmarshal([]byte(val), dst); err != nil {
+		return fmt.Errorf("%w: %w", ErrCacheValueType, err)
+	}
+	return nil
+}
+
+func (r *RedisCache) encode(key string, val any) (any, error) {
+	if r.vc == nil {
+		return val, nil
+	}
+	res, err := r.vc.encode(val)
+	if err != nil {
+		return nil, errs.NewKeyError(key, ErrCacheCodec, err)
+	}
+	return res, nil
 }
 
 func (r *RedisCache) Set(ctx context.Context, key string, val any, expiration time.Duration) error {
-	_, err := r.client.Set(ctx, key, val, expiration).Result() //成功了redis会返回一个OK
+	val, err := r.encode(key, val)
+	if err != nil {
+		return err
+	}
+	_, err = r.client.Set(ctx, key, val, expiration).Result() //成功了redis会返回一个OK
 	return err
 }
 
 func (r *RedisCache) Delete(ctx context.Context, key string) error {
-	_, err := r.client.Del(ctx, key).Result()
+	n, err := r.client.Del(ctx, key).Result()
+	if n > 0 {
+		r.recorder.RecordEviction(EvictionReasonDeleted)
+	}
+	return err
+}
+
+// GetMulti 用 MGET 一次往返，值都是 string
+func (r *RedisCache) GetMulti(ctx context.Context, keys []string) (map[string]any, error) {
+	if len(keys) == 0 {
+		return map[string]any{}, nil
+	}
+	vals, err := r.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]any, len(keys))
+	for i, val := range vals {
+		//不存在的key返回的是nil
+		if val == nil {
+			continue
+		}
+		if r.vc != nil {
+			if val, err = r.decode(keys[i], val.(string)); err != nil {
+				return nil, err
+			}
+		}
+		res[keys[i]] = val
+	}
+	r.recorder.RecordHits(len(res))
+	r.recorder.RecordMisses(len(keys) - len(res))
+	return res, nil
+}
+
+// SetMulti MSET 不能设置过期时间，所以用 pipeline 发多个 SET，还是一次往返
+func (r *RedisCache) SetMulti(ctx context.Context, entries map[string]any, expiration time.Duration) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	encoded := make(map[string]any, len(entries))
+	for key, val := range entries {
+		val, err := r.encode(key, val)
+		if err != nil {
+			return err
+		}
+		encoded[key] = val
+	}
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for key, val := range encoded {
+			pipe.Set(ctx, key, val, expiration)
+		}
+		return nil
+	})
 	return err
 }
+
+func (r *RedisCache) DeleteMulti(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	n, err := r.client.Del(ctx, keys...).Result()
+	for i := int64(0); i < n; i++ {
+		r.recorder.RecordEviction(EvictionReasonDeleted)
+	}
+	return err
+}
+
+// SetWithTags 写值和把key加到标签 set 里在一个 lua 脚本里完成。
+// 脚本里访问的key没有全部声明，集群模式下key和标签要用 hash tag 放到同一个 slot
+func (r *RedisCache) SetWithTags(ctx context.Context, key string, val any, expiration time.Duration, tags ...string) error {
+	val, err := r.encode(key, val)
+	if err != nil {
+		return err
+	}
+	keys := append([]string{key}, r.tagKeys(tags)...)
+	return r.client.Eval(ctx, luaSetWithTags, keys, val, pxMillis(expiration)).Err()
+}
+
+// InvalidateTags 在一个 lua 脚本里删掉标签下的所有key和标签本身，中间不会有别人写进来
+func (r *RedisCache) InvalidateTags(ctx context.Context, tags ...string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	n, err := r.client.Eval(ctx, luaInvalidateTags, r.tagKeys(tags)).Int64()
+	for i := int64(0); i < n; i++ {
+		r.recorder.RecordEviction(EvictionReasonDeleted)
+	}
+	return err
+}
+
+func (r *RedisCache) tagKeys(tags []string) []string {
+	res := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		res = append(res, r.tagPrefix+tag)
+	}
+	return res
+}
+
+func (r *RedisCache) SetNX(ctx context.Context, key string, val any, expiration time.Duration) (bool, error) {
+	val, err := r.encode(key, val)
+	if err != nil {
+		return false, err
+	}
+	return r.client.SetNX(ctx, key, val, expiration).Result()
+}
+
+// GetOrSet 读和写在一个 lua 脚本里，SET NX GET 要 redis 7 才支持
+func (r *RedisCache) GetOrSet(ctx context.Context, key string, val any, expiration time.Duration) (any, bool, error) {
+	encoded, err := r.encode(key, val)
+	if err != nil {
+		return nil, false, err
+	}
+	cur, err := r.client.Eval(ctx, luaGetOrSet, []string{key}, encoded, pxMillis(expiration)).Text()
+	if errors.Is(err, redis.Nil) {
+		r.recorder.RecordMisses(1)
+		return val, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	r.recorder.RecordHits(1)
+	if r.vc == nil {
+		return cur, true, nil
+	}
+	res, err := r.decode(key, cur)
+	if err != nil {
+		return nil, false, err
+	}
+	return res, true, nil
+}
+
+// GetWithVersion 版本号由redis里存的内容算出来，别的客户端直接 SET 改了值版本号也会变，
+// 写回一模一样的内容版本号不变，对 CompareAndSwap 来说没有区别
+func (r *RedisCache) GetWithVersion(ctx context.Context, key string) (any, uint64, error) {
+	raw, err := r.get(ctx, key)
+	if err != nil {
+		return nil, 0, err
+	}
+	var val any = raw
+	if r.vc != nil {
+		if val, err = r.decode(key, raw); err != nil {
+			return nil, 0, err
+		}
+	}
+	return val, valueVersion(raw), nil
+}
+
+func (r *RedisCache) CompareAndSwap(ctx context.Context, key string, version uint64, val any, expiration time.Duration) (bool, error) {
+	val, err := r.encode(key, val)
+	if err != nil {
+		return false, err
+	}
+	n, err := r.client.Eval(ctx, luaCompareAndSwap, []string{key},
+		fmt.Sprintf("%016x", version), val, pxMillis(expiration)).Int64()
+	return n == 1, err
+}
+
+// IncrBy 计数器按整数原样存，不经过 codec，Get 读出来是 string
+func (r *RedisCache) IncrBy(ctx context.Context, key string, delta int64) (int64, error) {
+	n, err := r.client.IncrBy(ctx, key, delta).Result()
+	return n, r.incrErr(key, err)
+}
+
+func (r *RedisCache) DecrBy(ctx context.Context, key string, delta int64) (int64, error) {
+	n, err := r.client.DecrBy(ctx, key, delta).Result()
+	return n, r.incrErr(key, err)
+}
+
+// incrErr 值不是整数、结果溢出的时候和本地缓存一样返回 ErrCacheValueType、ErrCacheValueOverflow
+func (r *RedisCache) incrErr(key string, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case strings.Contains(err.Error(), "not an integer"):
+		return errs.NewKeyError(key, ErrCacheValueType, err)
+	case strings.Contains(err.Error(), "overflow"):
+		return errs.NewKeyError(key, ErrCacheValueOverflow, err)
+	default:
+		return err
+	}
+}
+
+// pxMillis lua 脚本里的过期时间是毫秒，和 go-redis 的 Set 一样不到1毫秒的按1毫秒算，不然就变成不过期了
+func pxMillis(expiration time.Duration) int64 {
+	if expiration > 0 && expiration < time.Millisecond {
+		return 1
+	}
+	return expiration.Milliseconds()
+}
+
+// redisArgString 没有 codec 的时候值按 go-redis 写参数的方式转成字符串，读回来也是这个字符串。
+// 包装过的值（staleItem、xfetchItem）写成带前缀的字符串的时候用，go-redis 写不了的类型返回 ErrCacheCodec
+func redisArgString(val any) (string, error) {
+	switch v := val.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return fmt.Sprint(v), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 64), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		if v {
+			return "1", nil
+		}
+		return "0", nil
+	case encoding.BinaryMarshaler:
+		data, err := v.MarshalBinary()
+		return string(data), err
+	default:
+		return "", fmt.Errorf("%w: 没有设置 codec 的 RedisCache 存不了 %T，用 RedisCacheWithCodec", ErrCacheCodec, val)
+	}
+}
+
+// valueVersion 取 sha1 的前8个字节，和 compare_and_swap.lua 里的算法一致
+func valueVersion(raw string) uint64 {
+	sum := sha1.Sum([]byte(raw))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// Scan 就是 SCAN，同一个key可能返回多次。集群模式下一个 SCAN 只会发到一个节点，要对每个主节点分别 Scan
+func (r *RedisCache) Scan(ctx context.Context, pattern string, cursor uint64, count int64) ([]string, uint64, error) {
+	return r.client.Scan(ctx, cursor, pattern, count).Result()
+}
+
+// Range 用 SCAN 分批拿key再 MGET，不是快照，遍历期间的修改可能看得到也可能看不到，同一个key可能出现多次。
+// 标签 set 之类不是 string 的key会跳过
+func (r *RedisCache) Range(ctx context.Context, fn func(key string, val any) bool) error {
+	var cursor uint64
+	for {
+		keys, next, err := r.Scan(ctx, "", cursor, 100)
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			vals, err := r.client.MGet(ctx, keys...).Result()
+			if err != nil {
+				return err
+			}
+			for i, val := range vals {
+				//扫描之后被删掉了，或者不是 string
+				if val == nil {
+					continue
+				}
+				if r.vc != nil {
+					if val, err = r.decode(keys[i], val.(string)); err != nil {
+						return err
+					}
+				}
+				if !fn(keys[i], val) {
+					return nil
+				}
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// Stats 过期和内存淘汰是redis自己做的，这里统计不到，Entries 也一直是0
+func (r *RedisCache) Stats() Stats {
+	return r.recorder.Snapshot()
+}
@@ -2,9 +2,11 @@ package cache
 
 import (
 	"context"
+	"github.com/alicebob/miniredis/v2"
 	"github.com/golang/mock/gomock"
 	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/xuhaidong1/go-generic-tools/cache/mocks"
 	"testing"
 	"time"
@@ -59,3 +61,49 @@ func TestRedisCache_Set(t *testing.T) {
 		})
 	}
 }
+
+func TestRedisCache_SubMillisecondTTL(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := NewRedisCache(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	ctx := context.Background()
+	testCases := []struct {
+		name string
+		set  func(key string) error
+	}{
+		{
+			name: "set",
+			set: func(key string) error {
+				return c.Set(ctx, key, "v", time.Microsecond*500)
+			},
+		},
+		{
+			name: "set with tags",
+			set: func(key string) error {
+				return c.SetWithTags(ctx, key, "v", time.Microsecond*500, "tag1")
+			},
+		},
+		{
+			name: "get or set",
+			set: func(key string) error {
+				_, _, err := c.GetOrSet(ctx, key, "v", time.Microsecond*500)
+				return err
+			},
+		},
+		{
+			name: "compare and swap",
+			set: func(key string) error {
+				_, err := c.CompareAndSwap(ctx, key, 0, "v", time.Microsecond*500)
+				return err
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.NoError(t, tc.set("key1"))
+			// 不到1毫秒的按1毫秒算，不能变成不过期
+			assert.Equal(t, time.Millisecond, mr.TTL("key1"))
+			mr.FastForward(time.Millisecond)
+			assert.False(t, mr.Exists("key1"))
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package cache
+
+import (
+	"context"
+	"sort"
+)
+
+// ScanCache 按模式分批遍历key，运维清理、排查问题用，不要放在请求的热路径上。
+// pattern 是 redis 风格的 glob：* 任意个字符，? 一个字符，[abc]、[a-z]、[^a] 字符集，\ 转义，空串匹配所有key
+type ScanCache interface {
+	Cache
+	// Scan 从 cursor 开始返回一批匹配的key和下一次的游标，第一次传0，返回的游标是0表示遍历完了。
+	// count 只是一批大概返回多少个，小于等于0按10算
+	Scan(ctx context.Context, pattern string, cursor uint64, count int64) ([]string, uint64, error)
+}
+
+// Ranger 遍历所有key和值，fn 返回false停止。
+// 本地缓存遍历的是调用那一刻的快照，RedisCache 不是快照，细节看各自的实现
+type Ranger interface {
+	Range(ctx context.Context, fn func(key string, val any) bool) error
+}
+
+var (
+	_ Ranger = (*BulidinMapCache)(nil)
+	_ Ranger = (*LocalCache)(nil)
+	_ Ranger = (*ShardedCache)(nil)
+	_ Ranger = (*RedisCache)(nil)
+)
+
+// rangeEntries 本地缓存拷出来的快照交给 fn，ctx 取消了就停下来返回 ctx 的错误
+func rangeEntries(ctx context.Context, entries []snapshotEntry, fn func(key string, val any) bool) error {
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !fn(e.key, e.val) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// DeleteByPattern 删掉所有匹配 pattern 的key，返回删了多少个。
+// 一边遍历一边删，遍历期间新写进来的key可能删不到
+func DeleteByPattern(ctx context.Context, c ScanCache, pattern string) (int64, error) {
+	var (
+		cursor uint64
+		n      int64
+	)
+	for {
+		keys, next, err := c.Scan(ctx, pattern, cursor, 1000)
+		if err != nil {
+			return n, err
+		}
+		if len(keys) > 0 {
+			if err = DeleteMulti(ctx, c, keys...); err != nil {
+				return n, err
+			}
+			n += int64(len(keys))
+		}
+		if next == 0 {
+			return n, nil
+		}
+		cursor = next
+	}
+}
+
+// keyScanner 本地缓存的 Scan：key 按 fnv64a 从小到大返回，游标是下一批的哈希下界。
+// 哈希只和key有关，所以整个遍历期间一直存在的key刚好返回一次，中途删掉、新加的key可能返回也可能不返回。
+// 每次 Scan 都要过一遍所有key，和 redis 的 SCAN 一样不适合频繁调用
+type keyScanner struct {
+	pattern string
+	cursor  uint64
+	keys    []hashedKey
+}
+
+type hashedKey struct {
+	hash uint64
+	key  string
+}
+
+func newKeyScanner(pattern string, cursor uint64) *keyScanner {
+	return &keyScanner{pattern: pattern, cursor: cursor}
+}
+
+// add 调用方在锁里把没过期的key都加进来
+func (s *keyScanner) add(key string) {
+	if s.pattern != "" && !matchGlob(s.pattern, key) {
+		return
+	}
+	hash := fnv64a(key)
+	if hash < s.cursor {
+		return
+	}
+	s.keys = append(s.keys, hashedKey{hash: hash, key: key})
+}
+
+// result 哈希相同的key要在同一批返回，所以一批可能比 count 多几个
+func (s *keyScanner) result(count int64) ([]string, uint64) {
+	if count <= 0 {
+		count = 10
+	}
+	sort.Slice(s.keys, func(i, j int) bool {
+		if s.keys[i].hash != s.keys[j].hash {
+			return s.keys[i].hash < s.keys[j].hash
+		}
+		return s.keys[i].key < s.keys[j].key
+	})
+	n := len(s.keys)
+	var next uint64
+	if int64(n) > count {
+		last := s.keys[count-1].hash
+		n = int(count)
+		for n < len(s.keys) && s.keys[n].hash == last {
+			n++
+		}
+		//后面还有哈希更大的key，last+1 不会溢出
+		if n < len(s.keys) {
+			next = last + 1
+		}
+	}
+	res := make([]string, 0, n)
+	for _, k := range s.keys[:n] {
+		res = append(res, k.key)
+	}
+	return res, next
+}
+
+// matchGlob 和 redis 的 stringmatchlen 行为一致，按字节匹配。
+// 遇到 * 先记下位置，后面匹配不上再回来让 * 多吃一个字符，不会指数回溯
+func matchGlob(pattern, s string) bool {
+	px, sx := 0, 0
+	starPx, starSx := -1, -1
+	for px < len(pattern) || sx < len(s) {
+		if px < len(pattern) {
+			switch c := pattern[px]; c {
+			case '*':
+				starPx, starSx = px, sx+1
+				px++
+				continue
+			case '?':
+				if sx < len(s) {
+					px++
+					sx++
+					continue
+				}
+			case '[':
+				if sx < len(s) {
+					if ok, width := matchClass(pattern[px:], s[sx]); ok {
+						px += width
+						sx++
+						continue
+					}
+				}
+			case '\\':
+				lit, width := c, 1
+				if px+1 < len(pattern) {
+					lit, width = pattern[px+1], 2
+				}
+				if sx < len(s) && s[sx] == lit {
+					px += width
+					sx++
+					continue
+				}
+			default:
+				if sx < len(s) && s[sx] == c {
+					px++
+					sx++
+					continue
+				}
+			}
+		}
+		if starSx > 0 && starSx <= len(s) {
+			px, sx = starPx, starSx
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// matchClass pattern 以 [ 开头，返回 b 在不在字符集里和字符集占了几个字节，没有 ] 的话到结尾都算字符集
+func matchClass(pattern string, b byte) (bool, int) {
+	i := 1
+	negate := i < len(pattern) && pattern[i] == '^'
+	if negate {
+		i++
+	}
+	matched := false
+	for i < len(pattern) && pattern[i] != ']' {
+		c := pattern[i]
+		if c == '\\' && i+1 < len(pattern) {
+			i++
+			c = pattern[i]
+		}
+		if i+2 < len(pattern) && pattern[i+1] == '-' && pattern[i+2] != ']' {
+			lo, hi := c, pattern[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			if b >= lo && b <= hi {
+				matched = true
+			}
+			i += 3
+			continue
+		}
+		if b == c {
+			matched = true
+		}
+		i++
+	}
+	if i < len(pattern) {
+		//跳过 ]
+		i++
+	}
+	return matched != negate, i
+}
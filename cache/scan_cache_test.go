@@ -0,0 +1,215 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestMatchGlob(t *testing.T) {
+	testCases := []struct {
+		pattern string
+		key     string
+		want    bool
+	}{
+		{pattern: "*", key: "", want: true},
+		{pattern: "user:*", key: "user:1", want: true},
+		{pattern: "user:*", key: "order:1", want: false},
+		// 和 path.Match 不一样，* 可以匹配 /
+		{pattern: "user:*", key: "user:1/profile", want: true},
+		{pattern: "*:profile", key: "user:1:profile", want: true},
+		{pattern: "*a*b", key: "aaabab", want: true},
+		{pattern: "*a*b", key: "aaaba", want: false},
+		{pattern: "user:?", key: "user:1", want: true},
+		{pattern: "user:?", key: "user:12", want: false},
+		{pattern: "user:[12]", key: "user:2", want: true},
+		{pattern: "user:[0-9]", key: "user:7", want: true},
+		{pattern: "user:[^0-9]", key: "user:7", want: false},
+		{pattern: "user:[^0-9]", key: "user:a", want: true},
+		{pattern: `user:\*`, key: "user:*", want: true},
+		{pattern: `user:\*`, key: "user:1", want: false},
+		{pattern: "user:[a", key: "user:a", want: true},
+		{pattern: "user", key: "user:1", want: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.pattern+" "+tc.key, func(t *testing.T) {
+			assert.Equal(t, tc.want, matchGlob(tc.pattern, tc.key))
+		})
+	}
+}
+
+type rangeScanCache interface {
+	ScanCache
+	Ranger
+}
+
+func TestScanCache_Scan(t *testing.T) {
+	mr := miniredis.RunT(t)
+	testCases := []struct {
+		name  string
+		cache func() ScanCache
+	}{
+		{
+			name:  "buildin map",
+			cache: func() ScanCache { return NewBuildinMapCache() },
+		},
+		{
+			name:  "local",
+			cache: func() ScanCache { return NewLocalCache(nil) },
+		},
+		{
+			name:  "sharded",
+			cache: func() ScanCache { return newTestShardedCache(4) },
+		},
+		{
+			name:  "redis",
+			cache: func() ScanCache { return NewRedisCache(redis.NewClient(&redis.Options{Addr: mr.Addr()})) },
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			c := tc.cache()
+			for i := 0; i < 50; i++ {
+				require.NoError(t, c.Set(ctx, fmt.Sprintf("user:%d", i), i, time.Minute))
+			}
+			for i := 0; i < 5; i++ {
+				require.NoError(t, c.Set(ctx, fmt.Sprintf("order:%d", i), i, time.Minute))
+			}
+
+			seen := make(map[string]bool)
+			var cursor uint64
+			for {
+				keys, next, err := c.Scan(ctx, "user:*", cursor, 7)
+				require.NoError(t, err)
+				for _, key := range keys {
+					seen[key] = true
+				}
+				if next == 0 {
+					break
+				}
+				cursor = next
+			}
+			assert.Len(t, seen, 50)
+
+			n, err := DeleteByPattern(ctx, c, "order:*")
+			require.NoError(t, err)
+			assert.Equal(t, int64(5), n)
+			assertKeys(t, c, map[string]bool{"order:0": false, "order:4": false, "user:0": true})
+		})
+	}
+}
+
+func TestBuildinMapCache_ScanConsistent(t *testing.T) {
+	ctx := context.Background()
+	c := NewBuildinMapCache()
+	for i := 0; i < 100; i++ {
+		require.NoError(t, c.Set(ctx, fmt.Sprintf("key%d", i), i, 0))
+	}
+	seen := make(map[string]int)
+	var cursor uint64
+	for round := 0; ; round++ {
+		keys, next, err := c.Scan(ctx, "", cursor, 10)
+		require.NoError(t, err)
+		for _, key := range keys {
+			seen[key]++
+		}
+		// 遍历过程中一边删一边加
+		require.NoError(t, c.Delete(ctx, fmt.Sprintf("key%d", 90+round)))
+		require.NoError(t, c.Set(ctx, fmt.Sprintf("new%d", round), round, 0))
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	// 一直都在的key刚好返回一次
+	for i := 0; i < 90; i++ {
+		assert.Equal(t, 1, seen[fmt.Sprintf("key%d", i)])
+	}
+	for key, cnt := range seen {
+		assert.Equal(t, 1, cnt, key)
+	}
+}
+
+func TestLocalCaches_Range(t *testing.T) {
+	testCases := []struct {
+		name  string
+		cache func() rangeScanCache
+		// 关闭之后 Range 返回 ErrCacheClosed，LocalCache 关闭之后还能读
+		closedErr bool
+	}{
+		{
+			name:      "buildin map",
+			cache:     func() rangeScanCache { return NewBuildinMapCache() },
+			closedErr: true,
+		},
+		{
+			name:  "local",
+			cache: func() rangeScanCache { return NewLocalCache(nil) },
+		},
+		{
+			name:      "sharded",
+			cache:     func() rangeScanCache { return newTestShardedCache(4) },
+			closedErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			c := tc.cache()
+			require.NoError(t, c.Set(ctx, "key1", 1, time.Minute))
+			require.NoError(t, c.Set(ctx, "key2", 2, time.Minute))
+			require.NoError(t, c.Set(ctx, "expired", 3, time.Millisecond))
+			time.Sleep(time.Millisecond * 5)
+
+			// fn 里可以写缓存，不会死锁，写进去的key不在这次遍历里
+			res := make(map[string]any)
+			require.NoError(t, c.Range(ctx, func(key string, val any) bool {
+				res[key] = val
+				require.NoError(t, c.Set(ctx, "new", 4, time.Minute))
+				return true
+			}))
+			assert.Equal(t, map[string]any{"key1": 1, "key2": 2}, res)
+
+			cnt := 0
+			require.NoError(t, c.Range(ctx, func(key string, val any) bool {
+				cnt++
+				return false
+			}))
+			assert.Equal(t, 1, cnt)
+
+			cancelCtx, cancel := context.WithCancel(ctx)
+			cancel()
+			assert.Equal(t, context.Canceled, c.Range(cancelCtx, func(key string, val any) bool {
+				return true
+			}))
+			if tc.closedErr {
+				require.NoError(t, c.(io.Closer).Close())
+				assert.Equal(t, ErrCacheClosed, c.Range(ctx, func(key string, val any) bool {
+					return true
+				}))
+			}
+		})
+	}
+}
+
+func TestRedisCache_Range(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+	c := NewRedisCache(redis.NewClient(&redis.Options{Addr: mr.Addr()}), RedisCacheWithCodec(JSONCodec{}))
+	require.NoError(t, c.Set(ctx, "key1", "v1", time.Minute))
+	require.NoError(t, c.SetWithTags(ctx, "key2", "v2", time.Minute, "tag1"))
+	res := make(map[string]any)
+	require.NoError(t, c.Range(ctx, func(key string, val any) bool {
+		res[key] = val
+		return true
+	}))
+	// 标签 set 不是 string，跳过
+	assert.Equal(t, map[string]any{"key1": "v1", "key2": "v2"}, res)
+}
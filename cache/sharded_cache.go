@@ -0,0 +1,285 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"runtime"
+	"time"
+)
+
+// ShardedCache 分片的本地缓存，解决 BulidinMapCache 一把大锁在多核下的竞争问题。
+// key 按 fnv-1a 哈希分到 2 的幂个分片上，每个分片是一个独立的 BulidinMapCache，
+// 有自己的锁和过期轮询，轮询的时候也只锁住一个分片。
+// 注意 WithMaxEntries、WithMaxCost 之类的容量选项作用于单个分片，总容量是分片数乘以它。
+// 淘汰策略每个分片要有自己的实例，内置策略会自动给每个分片创建一个，自定义策略用 WithEvictionPolicyFactory 指定
+type ShardedCache struct {
+	shards []*BulidinMapCache
+	mask   uint64
+	//snapshotFile 所有分片写到同一个快照文件里
+	snapshotFile *snapshotFile
+	//sharedRecorder WithStatsRecorder 传进来的 recorder 所有分片共用，统计的时候只能算一次
+	sharedRecorder bool
+}
+
+// NewShardedCache shardCount 会向上取整到2的幂，小于等于0的时候按 GOMAXPROCS 的4倍来。
+// 分片不能共用一个策略，不然一个分片淘汰的时候会把别的分片的key挑出来：
+// WithEvictionPolicy 传进来的内置策略第一个分片直接用，别的分片各自新建一个同类型的；
+// 自定义策略没法复制，有多个分片的时候返回 ErrSharedEvictionPolicy
+func NewShardedCache(shardCount int, opts ...CacheOption) (*ShardedCache, error) {
+	if shardCount <= 0 {
+		shardCount = runtime.GOMAXPROCS(0) * 4
+	}
+	n := 1
+	for n < shardCount {
+		n <<= 1
+	}
+	//先把选项应用到一个空的缓存上看看有没有指定策略，检查完了再创建分片，出错的时候不用关已经启动的分片
+	probe := &BulidinMapCache{}
+	for _, opt := range opts {
+		opt(probe)
+	}
+	var cloner policyCloner
+	if probe.policy != nil && n > 1 {
+		var ok bool
+		if cloner, ok = probe.policy.(policyCloner); !ok {
+			return nil, ErrSharedEvictionPolicy
+		}
+	}
+	res := &ShardedCache{
+		shards:         make([]*BulidinMapCache, n),
+		mask:           uint64(n - 1),
+		sharedRecorder: probe.recorder != nil,
+	}
+	for i := range res.shards {
+		shardOpts := opts
+		if i > 0 && cloner != nil {
+			shardOpts = append(opts[:len(opts):len(opts)], WithEvictionPolicy(cloner.newEmpty()))
+		}
+		res.shards[i] = newBuildinMapCache(shardOpts...)
+	}
+	if shard := res.shards[0]; shard.snapshotPath != "" {
+		res.snapshotFile = newSnapshotFile(shard.snapshotPath, shard.snapshotInterval, res)
+		res.snapshotFile.start()
+	}
+	return res, nil
+}
+
+func (s *ShardedCache) shard(key string) *BulidinMapCache {
+	return s.shards[fnv64a(key)&s.mask]
+}
+
+func (s *ShardedCache) Get(ctx context.Context, key string) (any, error) {
+	return s.shard(key).Get(ctx, key)
+}
+
+func (s *ShardedCache) Set(ctx context.Context, key string, val any, expiration time.Duration) error {
+	return s.shard(key).Set(ctx, key, val, expiration)
+}
+
+func (s *ShardedCache) Delete(ctx context.Context, key string) error {
+	return s.shard(key).Delete(ctx, key)
+}
+
+func (s *ShardedCache) SetWithTags(ctx context.Context, key string, val any, expiration time.Duration, tags ...string) error {
+	return s.shard(key).SetWithTags(ctx, key, val, expiration, tags...)
+}
+
+// InvalidateTags 一个标签下的key可能分布在所有分片上，每个分片都要失效一遍
+func (s *ShardedCache) InvalidateTags(ctx context.Context, tags ...string) error {
+	for _, shard := range s.shards {
+		if err := shard.InvalidateTags(ctx, tags...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetMulti 按分片分组，每个分片只加一次锁
+func (s *ShardedCache) GetMulti(ctx context.Context, keys []string) (map[string]any, error) {
+	res := make(map[string]any, len(keys))
+	for shard, group := range s.group(keys) {
+		vals, err := shard.GetMulti(ctx, group)
+		if err != nil {
+			return res, err
+		}
+		for key, val := range vals {
+			res[key] = val
+		}
+	}
+	return res, nil
+}
+
+func (s *ShardedCache) SetMulti(ctx context.Context, entries map[string]any, expiration time.Duration) error {
+	groups := make(map[*BulidinMapCache]map[string]any)
+	for key, val := range entries {
+		shard := s.shard(key)
+		if groups[shard] == nil {
+			groups[shard] = make(map[string]any)
+		}
+		groups[shard][key] = val
+	}
+	for shard, group := range groups {
+		if err := shard.SetMulti(ctx, group, expiration); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *ShardedCache) DeleteMulti(ctx context.Context, keys ...string) error {
+	for shard, group := range s.group(keys) {
+		if err := shard.DeleteMulti(ctx, group...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *ShardedCache) group(keys []string) map[*BulidinMapCache][]string {
+	res := make(map[*BulidinMapCache][]string)
+	for _, key := range keys {
+		shard := s.shard(key)
+		res[shard] = append(res[shard], key)
+	}
+	return res
+}
+
+func (s *ShardedCache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return s.shard(key).TTL(ctx, key)
+}
+
+func (s *ShardedCache) Expire(key string, expiration time.Duration) error {
+	return s.shard(key).Expire(key, expiration)
+}
+
+// SetNX 原子操作只涉及一个key，交给它所在的分片
+func (s *ShardedCache) SetNX(ctx context.Context, key string, val any, expiration time.Duration) (bool, error) {
+	return s.shard(key).SetNX(ctx, key, val, expiration)
+}
+
+func (s *ShardedCache) GetOrSet(ctx context.Context, key string, val any, expiration time.Duration) (any, bool, error) {
+	return s.shard(key).GetOrSet(ctx, key, val, expiration)
+}
+
+func (s *ShardedCache) GetWithVersion(ctx context.Context, key string) (any, uint64, error) {
+	return s.shard(key).GetWithVersion(ctx, key)
+}
+
+func (s *ShardedCache) CompareAndSwap(ctx context.Context, key string, version uint64, val any, expiration time.Duration) (bool, error) {
+	return s.shard(key).CompareAndSwap(ctx, key, version, val, expiration)
+}
+
+func (s *ShardedCache) IncrBy(ctx context.Context, key string, delta int64) (int64, error) {
+	return s.shard(key).IncrBy(ctx, key, delta)
+}
+
+func (s *ShardedCache) DecrBy(ctx context.Context, key string, delta int64) (int64, error) {
+	return s.shard(key).DecrBy(ctx, key, delta)
+}
+
+// Range 每个分片各自是一个快照，分片之间不是同一时刻的
+func (s *ShardedCache) Range(ctx context.Context, fn func(key string, val any) bool) error {
+	for _, shard := range s.shards {
+		goon := true
+		err := shard.Range(ctx, func(key string, val any) bool {
+			goon = fn(key, val)
+			return goon
+		})
+		if err != nil || !goon {
+			return err
+		}
+	}
+	return nil
+}
+
+// Scan 哈希顺序是全局的，所有分片的key放在一起排序
+func (s *ShardedCache) Scan(ctx context.Context, pattern string, cursor uint64, count int64) ([]string, uint64, error) {
+	scanner := newKeyScanner(pattern, cursor)
+	for _, shard := range s.shards {
+		if err := shard.scan(scanner); err != nil {
+			return nil, 0, err
+		}
+	}
+	keys, next := scanner.result(count)
+	return keys, next, nil
+}
+
+// OnEvicted 给所有分片追加淘汰回调，回调可能被多个分片并发调用
+func (s *ShardedCache) OnEvicted(fn func(key string, val any)) {
+	for _, shard := range s.shards {
+		shard.lock.Lock()
+		shard.OnEvicted(fn)
+		shard.lock.Unlock()
+	}
+}
+
+func (s *ShardedCache) Close() error {
+	var err error
+	if s.snapshotFile != nil {
+		err = s.snapshotFile.stop()
+	}
+	for _, shard := range s.shards {
+		err = errors.Join(err, shard.Close())
+	}
+	return err
+}
+
+// Snapshot 所有分片写到一个快照里，分片数变了也能恢复
+func (s *ShardedCache) Snapshot(w io.Writer) error {
+	var entries []snapshotEntry
+	for _, shard := range s.shards {
+		res, err := shard.entries()
+		if err != nil {
+			return err
+		}
+		entries = append(entries, res...)
+	}
+	return writeSnapshot(w, s.shards[0].snapshotCodec, entries)
+}
+
+func (s *ShardedCache) Restore(r io.Reader) error {
+	entries, err := readSnapshot(r, s.shards[0].snapshotCodec)
+	if err != nil {
+		return err
+	}
+	groups := make(map[*BulidinMapCache][]snapshotEntry)
+	for _, e := range entries {
+		shard := s.shard(e.key)
+		groups[shard] = append(groups[shard], e)
+	}
+	for shard, group := range groups {
+		if err = shard.restore(group); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stats 所有分片的统计加在一起
+func (s *ShardedCache) Stats() Stats {
+	res := Stats{Evictions: make(map[EvictionReason]uint64)}
+	//共用的 recorder 只算第一个分片的，key的数量还是每个分片加起来。
+	//recorder 可能是不能比较的类型，不能拿来做 map 的key去重
+	for i, shard := range s.shards {
+		st := shard.Stats()
+		res.Entries += st.Entries
+		if i > 0 && s.sharedRecorder {
+			continue
+		}
+		res.Hits += st.Hits
+		res.Misses += st.Misses
+		res.Loads += st.Loads
+		res.LoadErrors += st.LoadErrors
+		res.TotalLoadTime += st.TotalLoadTime
+		for reason, cnt := range st.Evictions {
+			res.Evictions[reason] += cnt
+		}
+	}
+	return res
+}
+
+// ShardCount 分片数
+func (s *ShardedCache) ShardCount() int {
+	return len(s.shards)
+}
@@ -0,0 +1,149 @@
+package cache
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestShardedCache 测试里的选项都是合法的，出错了直接 panic
+func newTestShardedCache(shardCount int, opts ...CacheOption) *ShardedCache {
+	c, err := NewShardedCache(shardCount, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func TestNewShardedCache(t *testing.T) {
+	testCases := []struct {
+		name       string
+		shardCount int
+		wantCount  int
+	}{
+		{
+			name:       "power of two",
+			shardCount: 8,
+			wantCount:  8,
+		},
+		{
+			name:       "round up",
+			shardCount: 9,
+			wantCount:  16,
+		},
+		{
+			name:       "one",
+			shardCount: 1,
+			wantCount:  1,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := NewShardedCache(tc.shardCount)
+			require.NoError(t, err)
+			defer c.Close()
+			assert.Equal(t, tc.wantCount, c.ShardCount())
+		})
+	}
+}
+
+func TestShardedCache_EvictionPolicy(t *testing.T) {
+	testCases := []struct {
+		name string
+		opt  CacheOption
+	}{
+		{name: "factory", opt: WithEvictionPolicyFactory(LRU)},
+		// 内置策略每个分片自动新建一个
+		{name: "builtin policy", opt: WithEvictionPolicy(LRU())},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			c, err := NewShardedCache(4, WithMaxEntries(2), tc.opt)
+			require.NoError(t, err)
+			defer c.Close()
+			// 找三个落在同一个分片上的key
+			var keys []string
+			for i := 0; len(keys) < 3; i++ {
+				key := "key" + strconv.Itoa(i)
+				if c.shard(key) == c.shard("key0") {
+					keys = append(keys, key)
+				}
+			}
+			require.NoError(t, c.Set(ctx, keys[0], 0, time.Minute))
+			require.NoError(t, c.Set(ctx, keys[1], 1, time.Minute))
+			_, err = c.Get(ctx, keys[0])
+			require.NoError(t, err)
+			require.NoError(t, c.Set(ctx, keys[2], 2, time.Minute))
+			assertKeys(t, c, map[string]bool{keys[0]: true, keys[1]: false, keys[2]: true})
+
+			for i := 0; i < 100; i++ {
+				require.NoError(t, c.Set(ctx, "other"+strconv.Itoa(i), i, time.Minute))
+			}
+			// 每个分片的策略只跟踪自己的key
+			for _, shard := range c.shards {
+				assert.Equal(t, 2, len(shard.data))
+				assert.Equal(t, 2, len(shard.policy.(*lruPolicy).nodes))
+			}
+		})
+	}
+}
+
+func TestShardedCache_CustomPolicy(t *testing.T) {
+	policy := &customPolicy{EvictionPolicy: LRU()}
+	before := runtime.NumGoroutine()
+	_, err := NewShardedCache(4, WithMaxEntries(2), WithEvictionPolicy(policy))
+	assert.Equal(t, ErrSharedEvictionPolicy, err)
+	// 没有分片被创建，不会留下过期轮询的 goroutine
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before)
+
+	// 只有一个分片的时候不用复制
+	c, err := NewShardedCache(1, WithMaxEntries(2), WithEvictionPolicy(policy))
+	require.NoError(t, err)
+	defer c.Close()
+	assert.Same(t, policy, c.shards[0].policy)
+}
+
+// customPolicy 自定义策略，没有实现 policyCloner
+type customPolicy struct {
+	EvictionPolicy
+}
+
+func TestShardedCache_Concurrent(t *testing.T) {
+	c := newTestShardedCache(16)
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				key := strconv.Itoa(i) + "-" + strconv.Itoa(j)
+				_ = c.Set(ctx, key, j, time.Minute)
+				val, err := c.Get(ctx, key)
+				assert.NoError(t, err)
+				assert.Equal(t, j, val)
+			}
+		}(i)
+	}
+	wg.Wait()
+	ttl, err := c.TTL(ctx, "1-1")
+	require.NoError(t, err)
+	assert.True(t, ttl > 0 && ttl <= time.Minute)
+	require.NoError(t, c.Delete(ctx, "1-1"))
+	_, err = c.Get(ctx, "1-1")
+	assert.ErrorIs(t, err, ErrCacheKeyNotExist)
+
+	var evicted int
+	c.OnEvicted(func(key string, val any) {
+		evicted++
+	})
+	require.NoError(t, c.Close())
+	assert.Equal(t, 8*1000-1, evicted)
+	require.NoError(t, c.Close())
+}
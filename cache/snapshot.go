@@ -0,0 +1,206 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Snapshotter 能把内容导出来再导回去的本地缓存，发布重启之后用快照预热，避免冷启动把 DB 打挂
+type Snapshotter interface {
+	// Snapshot 导出所有没过期的key，值用配置的 Codec 序列化，过期时间存的是绝对时间
+	Snapshot(w io.Writer) error
+	// Restore 导入快照，已经过期的key直接跳过，同名的key会被覆盖，不会清空现有的数据
+	Restore(r io.Reader) error
+}
+
+var snapshotMagic = [4]byte{'C', 'S', 'N', '1'}
+
+type snapshotEntry struct {
+	key      string
+	val      any
+	deadline time.Time
+}
+
+// writeSnapshot 格式：魔数 | codec ID | 若干条记录，每条是 key长度 | key | 过期时间 | 值长度 | 值，
+// 长度是 uvarint，过期时间是 UnixNano 的小端 int64，0 表示不过期
+func writeSnapshot(w io.Writer, codec Codec, entries []snapshotEntry) error {
+	bw := bufio.NewWriter(w)
+	bw.Write(snapshotMagic[:])
+	bw.WriteByte(codec.ID())
+	var buf [binary.MaxVarintLen64]byte
+	for _, e := range entries {
+		data, err := codec.Marshal(e.val)
+		if err != nil {
+			return fmt.Errorf("%w: 快照序列化 %s 失败: %w", ErrCacheCodec, e.key, err)
+		}
+		var dl int64
+		if !e.deadline.IsZero() {
+			dl = e.deadline.UnixNano()
+		}
+		bw.Write(buf[:binary.PutUvarint(buf[:], uint64(len(e.key)))])
+		bw.WriteString(e.key)
+		binary.Write(bw, binary.LittleEndian, dl)
+		bw.Write(buf[:binary.PutUvarint(buf[:], uint64(len(data)))])
+		bw.Write(data)
+	}
+	//bufio.Writer 出错之后后面的写都是空操作，最后 Flush 会把第一个错误返回
+	return bw.Flush()
+}
+
+// readSnapshot 读出所有没过期的记录，读到一半出错的话什么都不返回，免得导入半份数据
+func readSnapshot(r io.Reader, codec Codec) ([]snapshotEntry, error) {
+	br := bufio.NewReader(r)
+	var magic [4]byte
+	if _, err := io.ReadFull(br, magic[:]); err != nil || magic != snapshotMagic {
+		return nil, ErrInvalidSnapshot
+	}
+	id, err := br.ReadByte()
+	if err != nil {
+		return nil, ErrInvalidSnapshot
+	}
+	if id != codec.ID() {
+		return nil, fmt.Errorf("%w: 快照的 codec 是 %d，当前配置的是 %d", ErrInvalidSnapshot, id, codec.ID())
+	}
+	var res []snapshotEntry
+	now := time.Now()
+	for {
+		key, err := readSnapshotBytes(br)
+		if err == io.EOF {
+			return res, nil
+		}
+		if err != nil {
+			return nil, ErrInvalidSnapshot
+		}
+		var dl int64
+		if err = binary.Read(br, binary.LittleEndian, &dl); err != nil {
+			return nil, ErrInvalidSnapshot
+		}
+		data, err := readSnapshotBytes(br)
+		if err != nil {
+			return nil, ErrInvalidSnapshot
+		}
+		var deadline time.Time
+		if dl != 0 {
+			deadline = time.Unix(0, dl)
+			if deadline.Before(now) {
+				continue
+			}
+		}
+		var val any
+		if err = codec.Unmarshal(data, &val); err != nil {
+			return nil, fmt.Errorf("%w: 快照反序列化 %s 失败: %w", ErrCacheCodec, key, err)
+		}
+		res = append(res, snapshotEntry{key: string(key), val: val, deadline: deadline})
+	}
+}
+
+// readSnapshotBytes 只有一条记录都没开始读的时候才返回 io.EOF
+func readSnapshotBytes(br *bufio.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	//防止坏数据导致一次分配巨大的内存
+	if n > 1<<30 {
+		return nil, ErrInvalidSnapshot
+	}
+	res := make([]byte, n)
+	if _, err = io.ReadFull(br, res); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return res, nil
+}
+
+// snapshotFile 启动的时候从文件恢复，之后每隔 interval 写一次快照，关闭的时候再写一次。
+// 写的时候先写临时文件再 rename，进程中途挂了也不会留下半份快照
+type snapshotFile struct {
+	path     string
+	interval time.Duration
+	target   Snapshotter
+	close    chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+	err      error
+}
+
+func newSnapshotFile(path string, interval time.Duration, target Snapshotter) *snapshotFile {
+	return &snapshotFile{
+		path:     path,
+		interval: interval,
+		target:   target,
+		close:    make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// start 文件不存在说明是第一次启动，不算错误
+func (f *snapshotFile) start() {
+	if err := f.restore(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("cache: 从 %s 恢复快照失败: %v", f.path, err)
+	}
+	if f.interval <= 0 {
+		close(f.done)
+		return
+	}
+	go func() {
+		defer close(f.done)
+		ticker := time.NewTicker(f.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := f.save(); err != nil {
+					log.Printf("cache: 写快照到 %s 失败: %v", f.path, err)
+				}
+			case <-f.close:
+				return
+			}
+		}
+	}()
+}
+
+func (f *snapshotFile) restore() error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return f.target.Restore(file)
+}
+
+func (f *snapshotFile) save() error {
+	tmp := f.path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = f.target.Snapshot(file)
+	if err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
+
+// stop 停掉定时任务，再写最后一次，可以重复调用
+func (f *snapshotFile) stop() error {
+	f.stopOnce.Do(func() {
+		close(f.close)
+		<-f.done
+		f.err = f.save()
+	})
+	return f.err
+}
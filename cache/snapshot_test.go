@@ -0,0 +1,182 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type snapshotUser struct {
+	Name string
+	Age  int
+}
+
+func init() {
+	gob.Register(snapshotUser{})
+}
+
+type snapshotCache interface {
+	Cache
+	Snapshotter
+	Close() error
+}
+
+func TestSnapshot_Restore(t *testing.T) {
+	testCases := []struct {
+		name     string
+		newCache func() snapshotCache
+	}{
+		{
+			name:     "buildin map",
+			newCache: func() snapshotCache { return NewBuildinMapCache() },
+		},
+		{
+			name:     "sharded",
+			newCache: func() snapshotCache { return newTestShardedCache(4) },
+		},
+		{
+			name:     "local",
+			newCache: func() snapshotCache { return NewLocalCache(nil) },
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			src := tc.newCache()
+			defer src.Close()
+			require.NoError(t, src.Set(ctx, "int", 1, time.Minute))
+			require.NoError(t, src.Set(ctx, "string", "Tom", time.Minute))
+			require.NoError(t, src.Set(ctx, "user", snapshotUser{Name: "Tom", Age: 18}, time.Minute))
+			require.NoError(t, src.Set(ctx, "short", 1, time.Millisecond*50))
+			var buf bytes.Buffer
+			require.NoError(t, src.Snapshot(&buf))
+			time.Sleep(time.Millisecond * 100)
+
+			dst := tc.newCache()
+			defer dst.Close()
+			require.NoError(t, dst.Restore(&buf))
+			wantVals := map[string]any{
+				"int":    1,
+				"string": "Tom",
+				"user":   snapshotUser{Name: "Tom", Age: 18},
+			}
+			for key, want := range wantVals {
+				val, err := dst.Get(ctx, key)
+				require.NoError(t, err)
+				assert.Equal(t, want, val)
+			}
+			// 快照之后才过期的key不会被导入
+			_, err := dst.Get(ctx, "short")
+			assert.True(t, IsKeyNotFound(err))
+		})
+	}
+}
+
+func TestSnapshot_Deadline(t *testing.T) {
+	ctx := context.Background()
+	src := NewBuildinMapCache()
+	defer src.Close()
+	require.NoError(t, src.Set(ctx, "forever", 1, 0))
+	require.NoError(t, src.Set(ctx, "minute", 1, time.Minute))
+	var buf bytes.Buffer
+	require.NoError(t, src.Snapshot(&buf))
+	srcTTL, err := src.TTL(ctx, "minute")
+	require.NoError(t, err)
+
+	dst := NewBuildinMapCache()
+	defer dst.Close()
+	require.NoError(t, dst.Restore(&buf))
+	// 存的是绝对时间，恢复之后剩余时间不会重新算
+	ttl, err := dst.TTL(ctx, "minute")
+	require.NoError(t, err)
+	assert.InDelta(t, srcTTL, ttl, float64(time.Second))
+	val, err := dst.Get(ctx, "forever")
+	require.NoError(t, err)
+	assert.Equal(t, 1, val)
+}
+
+func TestSnapshot_Invalid(t *testing.T) {
+	ctx := context.Background()
+	src := NewBuildinMapCache(WithSnapshotCodec(JSONCodec{}))
+	defer src.Close()
+	require.NoError(t, src.Set(ctx, "key1", "value1", time.Minute))
+	var buf bytes.Buffer
+	require.NoError(t, src.Snapshot(&buf))
+	data := buf.Bytes()
+
+	testCases := []struct {
+		name    string
+		data    []byte
+		opts    []CacheOption
+		wantErr error
+	}{
+		{
+			name:    "not snapshot",
+			data:    []byte("hello world"),
+			wantErr: ErrInvalidSnapshot,
+		},
+		{
+			name:    "codec mismatch",
+			data:    data,
+			wantErr: ErrInvalidSnapshot,
+		},
+		{
+			name:    "truncated",
+			data:    data[:len(data)-1],
+			opts:    []CacheOption{WithSnapshotCodec(JSONCodec{})},
+			wantErr: ErrInvalidSnapshot,
+		},
+		{
+			name: "json",
+			data: data,
+			opts: []CacheOption{WithSnapshotCodec(JSONCodec{})},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := NewBuildinMapCache(tc.opts...)
+			defer c.Close()
+			err := c.Restore(bytes.NewReader(tc.data))
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, err, tc.wantErr)
+				assert.Empty(t, c.keysAsSlice())
+				return
+			}
+			require.NoError(t, err)
+			val, err := c.Get(ctx, "key1")
+			require.NoError(t, err)
+			assert.Equal(t, "value1", val)
+		})
+	}
+}
+
+func TestSnapshot_File(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+	c := newTestShardedCache(4, WithSnapshotFile(path, time.Millisecond*50))
+	require.NoError(t, c.Set(ctx, "key1", "value1", time.Minute))
+	// 定时写
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}, time.Second, time.Millisecond*10)
+	// Close 的时候再写一次
+	require.NoError(t, c.Set(ctx, "key2", "value2", time.Minute))
+	require.NoError(t, c.Close())
+
+	// 重启之后自动从文件恢复
+	c = newTestShardedCache(8, WithSnapshotFile(path, 0))
+	defer c.Close()
+	for _, key := range []string{"key1", "key2"} {
+		_, err := c.Get(ctx, key)
+		assert.NoError(t, err)
+	}
+	_, err := os.Stat(path + ".tmp")
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,166 @@
+package cache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// EvictionReason key被移出缓存的原因
+type EvictionReason int
+
+const (
+	// EvictionReasonExpired 过期了，懒删除和轮询删除都算
+	EvictionReasonExpired EvictionReason = iota
+	// EvictionReasonCapacity 超过了数量或者开销的限制，被淘汰策略选中
+	EvictionReasonCapacity
+	// EvictionReasonDeleted 用户主动删除
+	EvictionReasonDeleted
+
+	evictionReasonCount
+)
+
+func (r EvictionReason) String() string {
+	switch r {
+	case EvictionReasonExpired:
+		return "expired"
+	case EvictionReasonCapacity:
+		return "capacity"
+	case EvictionReasonDeleted:
+		return "deleted"
+	default:
+		return "unknown"
+	}
+}
+
+// Stats 某一时刻的统计快照
+type Stats struct {
+	Hits   uint64
+	Misses uint64
+	// Loads 回源成功的次数，LoadErrors 回源失败的次数，TotalLoadTime 是两者的总耗时
+	Loads         uint64
+	LoadErrors    uint64
+	TotalLoadTime time.Duration
+	Evictions     map[EvictionReason]uint64
+	// Entries 当前key的数量，RedisCache 这种拿不到的是0
+	Entries int
+}
+
+// HitRatio 没有请求的时候是0
+func (s Stats) HitRatio() float64 {
+	total := s.Hits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(total)
+}
+
+// TotalEvictions 所有原因加起来的淘汰次数
+func (s Stats) TotalEvictions() uint64 {
+	var res uint64
+	for _, cnt := range s.Evictions {
+		res += cnt
+	}
+	return res
+}
+
+// StatsCache 能提供统计数据的缓存，装饰器会把自己的数据和底层缓存的合在一起
+type StatsCache interface {
+	Stats() Stats
+}
+
+// StatsRecorder 统计数据的埋点，各个缓存实现在对应的地方调用它。
+// 默认用 NewStatsRecorder，想把数据接到别的监控系统可以自己实现，注意要并发安全
+type StatsRecorder interface {
+	RecordHits(n int)
+	RecordMisses(n int)
+	// RecordLoad err 不为nil表示回源失败
+	RecordLoad(loadTime time.Duration, err error)
+	RecordEviction(reason EvictionReason)
+	// Snapshot 不需要填 Entries，由缓存自己填
+	Snapshot() Stats
+}
+
+// NewStatsRecorder 基于原子操作的 StatsRecorder
+func NewStatsRecorder() StatsRecorder {
+	return &atomicStatsRecorder{}
+}
+
+type atomicStatsRecorder struct {
+	hits          atomic.Uint64
+	misses        atomic.Uint64
+	loads         atomic.Uint64
+	loadErrors    atomic.Uint64
+	totalLoadTime atomic.Int64
+	evictions     [evictionReasonCount]atomic.Uint64
+}
+
+func (r *atomicStatsRecorder) RecordHits(n int) {
+	r.hits.Add(uint64(n))
+}
+
+func (r *atomicStatsRecorder) RecordMisses(n int) {
+	r.misses.Add(uint64(n))
+}
+
+func (r *atomicStatsRecorder) RecordLoad(loadTime time.Duration, err error) {
+	if err != nil {
+		r.loadErrors.Add(1)
+	} else {
+		r.loads.Add(1)
+	}
+	r.totalLoadTime.Add(int64(loadTime))
+}
+
+func (r *atomicStatsRecorder) RecordEviction(reason EvictionReason) {
+	if reason >= 0 && reason < evictionReasonCount {
+		r.evictions[reason].Add(1)
+	}
+}
+
+func (r *atomicStatsRecorder) Snapshot() Stats {
+	res := Stats{
+		Hits:          r.hits.Load(),
+		Misses:        r.misses.Load(),
+		Loads:         r.loads.Load(),
+		LoadErrors:    r.loadErrors.Load(),
+		TotalLoadTime: time.Duration(r.totalLoadTime.Load()),
+		Evictions:     make(map[EvictionReason]uint64, evictionReasonCount),
+	}
+	for i := range r.evictions {
+		if cnt := r.evictions[i].Load(); cnt > 0 {
+			res.Evictions[EvictionReason(i)] = cnt
+		}
+	}
+	return res
+}
+
+// noopStatsRecorder 直接用结构体字面量创建缓存、没有 recorder 的时候用它
+type noopStatsRecorder struct{}
+
+func (noopStatsRecorder) RecordHits(n int)                             {}
+func (noopStatsRecorder) RecordMisses(n int)                           {}
+func (noopStatsRecorder) RecordLoad(loadTime time.Duration, err error) {}
+func (noopStatsRecorder) RecordEviction(reason EvictionReason)         {}
+func (noopStatsRecorder) Snapshot() Stats                              { return Stats{} }
+
+func orNoopRecorder(r StatsRecorder) StatsRecorder {
+	if r == nil {
+		return noopStatsRecorder{}
+	}
+	return r
+}
+
+// mergeStats 装饰器的统计：命中率按装饰器看到的算，回源次数加在一起，淘汰和key的数量用底层缓存的
+func mergeStats(own Stats, inner Cache) Stats {
+	sc, ok := inner.(StatsCache)
+	if !ok {
+		return own
+	}
+	in := sc.Stats()
+	own.Loads += in.Loads
+	own.LoadErrors += in.LoadErrors
+	own.TotalLoadTime += in.TotalLoadTime
+	own.Evictions = in.Evictions
+	own.Entries = in.Entries
+	return own
+}
@@ -0,0 +1,175 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestBulidinMapCache_Stats(t *testing.T) {
+	ctx := context.Background()
+	c := NewBuildinMapCache(WithMaxEntries(2))
+	defer c.Close()
+	require.NoError(t, c.Set(ctx, "key1", 1, time.Minute))
+	require.NoError(t, c.Set(ctx, "key2", 2, time.Millisecond))
+	require.NoError(t, c.Set(ctx, "key3", 3, time.Minute))
+	require.NoError(t, c.Set(ctx, "key4", 4, time.Minute))
+	require.NoError(t, c.Delete(ctx, "key4"))
+	_, err := c.Get(ctx, "key3")
+	require.NoError(t, err)
+	_, err = c.Get(ctx, "key1")
+	assert.Error(t, err)
+	require.NoError(t, c.Set(ctx, "key5", 5, time.Millisecond))
+	time.Sleep(time.Millisecond * 5)
+	_, err = c.Get(ctx, "key5")
+	assert.Error(t, err)
+
+	stats := c.Stats()
+	assert.Equal(t, uint64(1), stats.Hits)
+	assert.Equal(t, uint64(2), stats.Misses)
+	assert.Equal(t, map[EvictionReason]uint64{
+		EvictionReasonCapacity: 2,
+		EvictionReasonDeleted:  1,
+		EvictionReasonExpired:  1,
+	}, stats.Evictions)
+	assert.Equal(t, uint64(4), stats.TotalEvictions())
+	assert.Equal(t, 1, stats.Entries)
+	assert.InDelta(t, 1.0/3, stats.HitRatio(), 0.0001)
+}
+
+func TestShardedCache_Stats(t *testing.T) {
+	ctx := context.Background()
+	c := newTestShardedCache(4)
+	defer c.Close()
+	for _, key := range []string{"key1", "key2", "key3"} {
+		require.NoError(t, c.Set(ctx, key, key, time.Minute))
+		_, err := c.Get(ctx, key)
+		require.NoError(t, err)
+	}
+	_, err := c.Get(ctx, "key4")
+	assert.Error(t, err)
+	stats := c.Stats()
+	assert.Equal(t, uint64(3), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, 3, stats.Entries)
+}
+
+func TestShardedCache_SharedRecorder(t *testing.T) {
+	ctx := context.Background()
+	recorder := NewStatsRecorder()
+	c := newTestShardedCache(4, WithStatsRecorder(recorder))
+	defer c.Close()
+	require.NoError(t, c.Set(ctx, "key1", "key1", time.Minute))
+	_, err := c.Get(ctx, "key1")
+	require.NoError(t, err)
+	_, err = c.Get(ctx, "key2")
+	assert.Error(t, err)
+	require.NoError(t, c.Delete(ctx, "key1"))
+	// 所有分片共用一个 recorder，不能算4次
+	stats := c.Stats()
+	assert.Equal(t, uint64(1), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, uint64(1), stats.TotalEvictions())
+	assert.Equal(t, recorder.Snapshot().Hits, stats.Hits)
+
+	// 不能比较的 recorder 也不会 panic
+	c2 := newTestShardedCache(4, WithStatsRecorder(sliceRecorder{StatsRecorder: NewStatsRecorder()}))
+	defer c2.Close()
+	_, err = c2.Get(ctx, "key1")
+	assert.Error(t, err)
+	assert.Equal(t, uint64(1), c2.Stats().Misses)
+}
+
+// sliceRecorder 带着切片，是不能比较的值类型
+type sliceRecorder struct {
+	StatsRecorder
+	tags []string
+}
+
+type cacheWithStats interface {
+	Cache
+	StatsCache
+}
+
+func TestReadThroughCache_Stats(t *testing.T) {
+	ctx := context.Background()
+	loadErr := errors.New("db down")
+	loadFunc := func(ctx context.Context, key string) (any, error) {
+		if key == "bad" {
+			return nil, loadErr
+		}
+		time.Sleep(time.Millisecond)
+		return key, nil
+	}
+	testCases := []struct {
+		name  string
+		cache func(local Cache) cacheWithStats
+	}{
+		{
+			name: "read through",
+			cache: func(local Cache) cacheWithStats {
+				return NewReadThroughCache(local, time.Minute, loadFunc)
+			},
+		},
+		{
+			name: "single flight",
+			cache: func(local Cache) cacheWithStats {
+				return NewSingleFlightCache(local, time.Minute, loadFunc)
+			},
+		},
+		{
+			name: "bloom filter",
+			cache: func(local Cache) cacheWithStats {
+				return NewBloomFilterCache(local, time.Minute, loadFunc, func(ctx context.Context, key string) bool {
+					return true
+				})
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			local := NewLocalCache(nil)
+			c := tc.cache(local)
+			for i := 0; i < 3; i++ {
+				val, err := c.Get(ctx, "key1")
+				require.NoError(t, err)
+				assert.Equal(t, "key1", val)
+			}
+			_, err := c.Get(ctx, "bad")
+			assert.ErrorIs(t, err, loadErr)
+			require.NoError(t, c.Delete(ctx, "key1"))
+
+			stats := c.Stats()
+			assert.Equal(t, uint64(2), stats.Hits)
+			assert.Equal(t, uint64(2), stats.Misses)
+			assert.Equal(t, uint64(1), stats.Loads)
+			assert.Equal(t, uint64(1), stats.LoadErrors)
+			assert.GreaterOrEqual(t, stats.TotalLoadTime, time.Millisecond)
+			// 淘汰和key的数量来自底层的 LocalCache
+			assert.Equal(t, uint64(1), stats.Evictions[EvictionReasonDeleted])
+			assert.Equal(t, 0, stats.Entries)
+		})
+	}
+}
+
+func TestRedisCache_Stats(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+	c := NewRedisCache(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	require.NoError(t, c.Set(ctx, "key1", "val1", time.Minute))
+	_, err := c.Get(ctx, "key1")
+	require.NoError(t, err)
+	_, err = c.Get(ctx, "key2")
+	assert.Error(t, err)
+	require.NoError(t, c.Delete(ctx, "key1"))
+	require.NoError(t, c.Delete(ctx, "key1"))
+	stats := c.Stats()
+	assert.Equal(t, uint64(1), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, uint64(1), stats.Evictions[EvictionReasonDeleted])
+}
@@ -0,0 +1,76 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// TagCache 按标签批量失效，比如用户资料变了，把所有依赖它的页面片段一起删掉。
+// 标签只增不减：key 被覆盖写之后原来的标签还在，失效的时候宁可多删也不会漏删
+type TagCache interface {
+	Cache
+	SetWithTags(ctx context.Context, key string, val any, expiration time.Duration, tags ...string) error
+	// InvalidateTags 删掉带有任意一个标签的所有key
+	InvalidateTags(ctx context.Context, tags ...string) error
+}
+
+// tagIndex 本地缓存用的标签索引，没用过标签的时候两个 map 都是nil，不占内存。
+// key 被删除、淘汰、过期的时候要调 remove，不然索引会越来越大。不是并发安全的，由缓存的锁保护
+type tagIndex struct {
+	//keys 标签 -> 带这个标签的key
+	keys map[string]map[string]struct{}
+	//tags key -> 它的标签
+	tags map[string][]string
+}
+
+func (t *tagIndex) add(key string, tags []string) {
+	if len(tags) == 0 {
+		return
+	}
+	if t.keys == nil {
+		t.keys = make(map[string]map[string]struct{})
+		t.tags = make(map[string][]string)
+	}
+	for _, tag := range tags {
+		set, ok := t.keys[tag]
+		if !ok {
+			set = make(map[string]struct{})
+			t.keys[tag] = set
+		}
+		if _, ok = set[key]; ok {
+			continue
+		}
+		set[key] = struct{}{}
+		t.tags[key] = append(t.tags[key], tag)
+	}
+}
+
+func (t *tagIndex) remove(key string) {
+	for _, tag := range t.tags[key] {
+		set := t.keys[tag]
+		delete(set, key)
+		if len(set) == 0 {
+			delete(t.keys, tag)
+		}
+	}
+	delete(t.tags, key)
+}
+
+// keysOf 带有任意一个标签的key，去重
+func (t *tagIndex) keysOf(tags []string) []string {
+	var res []string
+	seen := make(map[string]struct{})
+	for _, tag := range tags {
+		for key := range t.keys[tag] {
+			if _, ok := seen[key]; !ok {
+				seen[key] = struct{}{}
+				res = append(res, key)
+			}
+		}
+	}
+	return res
+}
+
+func (t *tagIndex) reset() {
+	t.keys, t.tags = nil, nil
+}
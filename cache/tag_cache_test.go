@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestTagCache_InvalidateTags(t *testing.T) {
+	mr := miniredis.RunT(t)
+	testCases := []struct {
+		name  string
+		cache func() TagCache
+	}{
+		{
+			name:  "buildin map",
+			cache: func() TagCache { return NewBuildinMapCache() },
+		},
+		{
+			name:  "local",
+			cache: func() TagCache { return NewLocalCache(nil) },
+		},
+		{
+			name:  "sharded",
+			cache: func() TagCache { return newTestShardedCache(4) },
+		},
+		{
+			name:  "redis",
+			cache: func() TagCache { return NewRedisCache(redis.NewClient(&redis.Options{Addr: mr.Addr()})) },
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			c := tc.cache()
+			require.NoError(t, c.SetWithTags(ctx, "frag1", "v1", time.Minute, "user:1"))
+			require.NoError(t, c.SetWithTags(ctx, "frag2", "v2", time.Minute, "user:1", "page:home"))
+			require.NoError(t, c.SetWithTags(ctx, "frag3", "v3", time.Minute, "page:home"))
+			require.NoError(t, c.Set(ctx, "other", "v4", time.Minute))
+
+			require.NoError(t, c.InvalidateTags(ctx, "user:1"))
+			assertKeys(t, c, map[string]bool{"frag1": false, "frag2": false, "frag3": true, "other": true})
+			// frag2 已经删了，再失效也没问题
+			require.NoError(t, c.InvalidateTags(ctx, "page:home", "not exist"))
+			assertKeys(t, c, map[string]bool{"frag3": false, "other": true})
+
+			// 失效之后重新写，标签要重新生效
+			require.NoError(t, c.SetWithTags(ctx, "frag1", "v1", time.Minute, "user:1"))
+			require.NoError(t, c.InvalidateTags(ctx, "user:1"))
+			assertKeys(t, c, map[string]bool{"frag1": false})
+		})
+	}
+}
+
+func assertKeys(t *testing.T, c Cache, keys map[string]bool) {
+	for key, exist := range keys {
+		_, err := c.Get(context.Background(), key)
+		if exist {
+			assert.NoError(t, err, key)
+		} else {
+			assert.True(t, IsKeyNotFound(err), key)
+		}
+	}
+}
+
+func TestBuildinMapCache_TagIndex(t *testing.T) {
+	ctx := context.Background()
+	c := NewBuildinMapCache()
+	require.NoError(t, c.SetWithTags(ctx, "key1", "v1", time.Millisecond*10, "tag1", "tag2"))
+	require.NoError(t, c.SetWithTags(ctx, "key2", "v2", time.Minute, "tag2"))
+	require.NoError(t, c.Delete(ctx, "key2"))
+	time.Sleep(time.Millisecond * 20)
+	_, err := c.Get(ctx, "key1")
+	assert.True(t, IsKeyNotFound(err))
+	// 删除、过期之后索引也要清掉
+	assert.Empty(t, c.tags.keys)
+	assert.Empty(t, c.tags.tags)
+}
+
+func TestRedisCache_TagTTL(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+	c := NewRedisCache(redis.NewClient(&redis.Options{Addr: mr.Addr()}), RedisCacheWithTagPrefix("t:"))
+	require.NoError(t, c.SetWithTags(ctx, "key1", "v1", time.Minute, "tag1"))
+	require.NoError(t, c.SetWithTags(ctx, "key2", "v2", time.Second, "tag1"))
+	// 标签的过期时间跟着最长的key走
+	assert.Equal(t, time.Minute, mr.TTL("t:tag1"))
+	members, err := mr.SMembers("t:tag1")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"key1", "key2"}, members)
+
+	require.NoError(t, c.SetWithTags(ctx, "key3", "v3", 0, "tag1"))
+	assert.Equal(t, time.Duration(0), mr.TTL("t:tag1"))
+
+	require.NoError(t, c.InvalidateTags(ctx, "tag1"))
+	assert.False(t, mr.Exists("t:tag1"))
+	assert.Equal(t, uint64(3), c.Stats().Evictions[EvictionReasonDeleted])
+}
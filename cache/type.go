@@ -3,6 +3,8 @@ package cache
 import (
 	"context"
 	"errors"
+	"github.com/xuhaidong1/go-generic-tools/cache/errs"
+	"github.com/xuhaidong1/go-generic-tools/container/queue"
 	"time"
 )
 
@@ -23,13 +25,37 @@ type LoadFunc func(ctx context.Context, key string) (any, error)
 type StoreFunc func(ctx context.Context, key string, val any) error
 type BloomFilter func(ctx context.Context, key string) bool
 
+// 错误分类见 errs 包，这里起个别名方便使用。和key有关的错误都是 *errs.KeyError，
+// 可以用 errors.As 拿到key，判断类型一律用 errors.Is
 var (
-	ErrCacheClosed      = errors.New("缓存已经被关闭")
-	ErrCacheKeyNotExist = errors.New("key不存在")
-	ErrCacheFull        = errors.New("缓存满了")
+	ErrCacheClosed      = errs.ErrClosed
+	ErrCacheKeyNotExist = errs.ErrKeyNotFound
+	ErrCacheFull        = errs.ErrFull
+	ErrLoadFailed       = errs.ErrLoadFailed
+	ErrCacheCodec       = errs.ErrCodec
+	ErrCacheValueType   = errors.New("缓存值类型不匹配")
+	ErrCacheValueTooBig = errors.New("缓存值超过了单个key的大小上限")
+	//ErrCacheValueOverflow IncrBy、DecrBy 的结果超出了 int64
+	ErrCacheValueOverflow = errors.New("计数器溢出")
+	ErrInvalidSnapshot    = errors.New("无法解析缓存快照")
+	//ErrSharedEvictionPolicy ShardedCache 的分片没法共用 WithEvictionPolicy 传进来的自定义策略
+	ErrSharedEvictionPolicy = errors.New("分片不能共用一个自定义淘汰策略，用 WithEvictionPolicyFactory")
+	//ErrDataNotFound 数据源里也没有这个key，LoadFunc 可以返回它，负缓存命中的时候也返回它
+	ErrDataNotFound = errors.New("数据源里没有这个key")
 )
 
+// IsKeyNotFound 缓存没命中，RedisCache 的 redis.Nil 也映射成了这个
+func IsKeyNotFound(err error) bool {
+	return errors.Is(err, ErrCacheKeyNotExist)
+}
+
 type item struct {
 	Val      any
 	Deadline time.Time
+	//cost 由 weigher 算出来的开销，没有限制开销的时候是0
+	cost int64
+	//version 每次写入的时候更新，CompareAndSwap 用
+	version uint64
+	//timer 用时间轮过期的时候才有
+	timer *queue.Timer[string]
 }
@@ -0,0 +1,157 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"github.com/xuhaidong1/go-generic-tools/cache/errs"
+	"time"
+)
+
+// TypedCache 在 Cache 上包一层泛型，读写的值类型由编译器检查，业务代码不用再到处写类型断言。
+// 底层仍然是 any 版本的 Cache，key 通过 keyFunc 转成 string，默认用 fmt.Sprint
+type TypedCache[K comparable, V any] struct {
+	cache   Cache
+	keyFunc func(key K) string
+}
+
+type TypedLoadFunc[K comparable, V any] func(ctx context.Context, key K) (V, error)
+type TypedStoreFunc[K comparable, V any] func(ctx context.Context, key K, val V) error
+
+type TypedCacheOption[K comparable, V any] func(c *TypedCache[K, V])
+
+// WithKeyFunc 自定义 key 转 string 的方式，比如加业务前缀
+func WithKeyFunc[K comparable, V any](fn func(key K) string) TypedCacheOption[K, V] {
+	return func(c *TypedCache[K, V]) {
+		c.keyFunc = fn
+	}
+}
+
+func NewTypedCache[K comparable, V any](cache Cache, opts ...TypedCacheOption[K, V]) *TypedCache[K, V] {
+	res := &TypedCache[K, V]{
+		cache: cache,
+		keyFunc: func(key K) string {
+			return fmt.Sprint(key)
+		},
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res
+}
+
+func (c *TypedCache[K, V]) Get(ctx context.Context, key K) (V, error) {
+	var res V
+	//RedisCache 这种会序列化的，直接解码到 V
+	if dc, ok := c.cache.(DecodingCache); ok {
+		if err := dc.GetInto(ctx, c.keyFunc(key), &res); err != nil {
+			var zero V
+			return zero, err
+		}
+		return res, nil
+	}
+	val, err := c.cache.Get(ctx, c.keyFunc(key))
+	if err != nil {
+		return res, err
+	}
+	res, ok := val.(V)
+	if !ok {
+		//底层cache被别人用any接口写进了别的类型
+		return res, fmt.Errorf("%w: 期望 %T，实际 %T", ErrCacheValueType, res, val)
+	}
+	return res, nil
+}
+
+func (c *TypedCache[K, V]) Set(ctx context.Context, key K, val V, expiration time.Duration) error {
+	return c.cache.Set(ctx, c.keyFunc(key), val, expiration)
+}
+
+func (c *TypedCache[K, V]) Delete(ctx context.Context, key K) error {
+	return c.cache.Delete(ctx, c.keyFunc(key))
+}
+
+// Untyped 返回底层的 any 版本 Cache，方便继续套用现有的装饰器
+func (c *TypedCache[K, V]) Untyped() Cache {
+	return c.cache
+}
+
+// NewUntypedCache 把 TypedCache 适配回 Cache 接口，Set 的时候检查值类型，类型不对直接返回错误，
+// 这样只认识 Cache 接口的老代码也没法往里面写错类型
+func NewUntypedCache[V any](c *TypedCache[string, V]) Cache {
+	return &untypedCache[V]{typed: c}
+}
+
+type untypedCache[V any] struct {
+	typed *TypedCache[string, V]
+}
+
+func (u *untypedCache[V]) Get(ctx context.Context, key string) (any, error) {
+	return u.typed.Get(ctx, key)
+}
+
+func (u *untypedCache[V]) Set(ctx context.Context, key string, val any, expiration time.Duration) error {
+	v, ok := val.(V)
+	if !ok {
+		return fmt.Errorf("%w: 期望 %T，实际 %T", ErrCacheValueType, v, val)
+	}
+	return u.typed.Set(ctx, key, v, expiration)
+}
+
+func (u *untypedCache[V]) Delete(ctx context.Context, key string) error {
+	return u.typed.Delete(ctx, key)
+}
+
+// TypedReadThroughCache 泛型版本的 ReadThroughCache
+type TypedReadThroughCache[K comparable, V any] struct {
+	*TypedCache[K, V]
+	Expiration time.Duration
+	LoadFunc   TypedLoadFunc[K, V]
+}
+
+func NewTypedReadThroughCache[K comparable, V any](cache Cache, expiration time.Duration,
+	loadFunc TypedLoadFunc[K, V], opts ...TypedCacheOption[K, V]) *TypedReadThroughCache[K, V] {
+	return &TypedReadThroughCache[K, V]{
+		TypedCache: NewTypedCache[K, V](cache, opts...),
+		Expiration: expiration,
+		LoadFunc:   loadFunc,
+	}
+}
+
+func (c *TypedReadThroughCache[K, V]) Get(ctx context.Context, key K) (V, error) {
+	val, err := c.TypedCache.Get(ctx, key)
+	if err == nil {
+		return val, nil
+	}
+	if !IsKeyNotFound(err) {
+		return val, err
+	}
+	val, err = c.LoadFunc(ctx, key)
+	if err != nil {
+		//包一层错误信息 方便定位
+		return val, errs.NewKeyError(c.keyFunc(key), ErrLoadFailed, err)
+	}
+	//回写缓存失败不影响这次读
+	_ = c.Set(ctx, key, val, c.Expiration)
+	return val, nil
+}
+
+// TypedWriteThroughCache 泛型版本的 WriteThroughCache
+type TypedWriteThroughCache[K comparable, V any] struct {
+	*TypedCache[K, V]
+	StoreFunc TypedStoreFunc[K, V]
+}
+
+func NewTypedWriteThroughCache[K comparable, V any](cache Cache, storeFunc TypedStoreFunc[K, V],
+	opts ...TypedCacheOption[K, V]) *TypedWriteThroughCache[K, V] {
+	return &TypedWriteThroughCache[K, V]{
+		TypedCache: NewTypedCache[K, V](cache, opts...),
+		StoreFunc:  storeFunc,
+	}
+}
+
+func (c *TypedWriteThroughCache[K, V]) Set(ctx context.Context, key K, val V, expiration time.Duration) error {
+	err := c.StoreFunc(ctx, key, val)
+	if err != nil {
+		return err
+	}
+	return c.TypedCache.Set(ctx, key, val, expiration)
+}
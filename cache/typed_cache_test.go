@@ -0,0 +1,93 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type typedUser struct {
+	ID   int
+	Name string
+}
+
+func TestTypedCache_Get(t *testing.T) {
+	testCases := []struct {
+		name    string
+		before  func(c Cache)
+		key     int
+		wantVal typedUser
+		wantErr error
+	}{
+		{
+			name: "exist",
+			before: func(c Cache) {
+				_ = c.Set(context.Background(), "1", typedUser{ID: 1, Name: "Tom"}, time.Minute)
+			},
+			key:     1,
+			wantVal: typedUser{ID: 1, Name: "Tom"},
+		},
+		{
+			name:    "not exist",
+			before:  func(c Cache) {},
+			key:     2,
+			wantErr: ErrCacheKeyNotExist,
+		},
+		{
+			name: "wrong type",
+			before: func(c Cache) {
+				_ = c.Set(context.Background(), "3", "Tom", time.Minute)
+			},
+			key:     3,
+			wantErr: ErrCacheValueType,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := NewBuildinMapCache()
+			tc.before(c)
+			tcache := NewTypedCache[int, typedUser](c)
+			val, err := tcache.Get(context.Background(), tc.key)
+			assert.True(t, errors.Is(err, tc.wantErr))
+			if err != nil {
+				return
+			}
+			assert.Equal(t, tc.wantVal, val)
+		})
+	}
+}
+
+func TestUntypedCache_Set(t *testing.T) {
+	c := NewUntypedCache[int](NewTypedCache[string, int](NewBuildinMapCache()))
+	err := c.Set(context.Background(), "key1", "abc", time.Minute)
+	assert.True(t, errors.Is(err, ErrCacheValueType))
+	err = c.Set(context.Background(), "key1", 12, time.Minute)
+	require.NoError(t, err)
+	val, err := c.Get(context.Background(), "key1")
+	require.NoError(t, err)
+	assert.Equal(t, 12, val)
+}
+
+func TestTypedReadThroughCache_Get(t *testing.T) {
+	cnt := 0
+	c := NewTypedReadThroughCache[int, typedUser](NewBuildinMapCache(), time.Minute,
+		func(ctx context.Context, key int) (typedUser, error) {
+			cnt++
+			return typedUser{ID: key, Name: "user" + strconv.Itoa(key)}, nil
+		}, WithKeyFunc[int, typedUser](func(key int) string {
+			return "/user/" + strconv.Itoa(key)
+		}))
+	for i := 0; i < 3; i++ {
+		val, err := c.Get(context.Background(), 10)
+		require.NoError(t, err)
+		assert.Equal(t, typedUser{ID: 10, Name: "user10"}, val)
+	}
+	assert.Equal(t, 1, cnt)
+	val, err := c.Untyped().Get(context.Background(), "/user/10")
+	require.NoError(t, err)
+	assert.Equal(t, typedUser{ID: 10, Name: "user10"}, val)
+}
@@ -2,29 +2,369 @@ package cache
 
 import (
 	"context"
-	"log"
+	"fmt"
+	"github.com/xuhaidong1/go-generic-tools/cache/errs"
+	"sync"
+	"time"
 )
 
-// WriteBackCache 采用localcache的删除回调机制实现缓存过期刷新到db
+// BatchStoreFunc 批量写DB，要么全部成功要么返回错误
+type BatchStoreFunc func(ctx context.Context, entries map[string]any) error
+
+// DeleteFunc 从DB删掉key
+type DeleteFunc func(ctx context.Context, key string) error
+
+// WriteBackCache 写回cache：Set 只写缓存并把key记为脏数据，后台定期（或者脏数据攒够了）批量刷新到DB，
+// 刷新失败按指数退避重试，还是失败就放回去等下一轮。
+// 脏数据单独存了一份，所以key在缓存里过期了也不会丢，刷新之前读也能读到。
+// Close 会阻塞到所有脏数据都刷新完，或者超时。
+// Delete 在脏数据里记一个删除标记，盖掉还没刷新的写，之后读不到这个key。设置了 DeleteFunc 的话刷新的时候删DB，
+// 没设置的话只是丢掉还没刷新的写，DB里已有的数据（包括正在刷新的那一份）不动
 type WriteBackCache struct {
 	*LocalCache
 	StoreFunc
+	batchStore BatchStoreFunc
+	deleteFunc DeleteFunc
+
+	//mutex 同时保护脏数据和写 LocalCache，保证两边的值一致
+	mutex sync.Mutex
+	dirty map[string]any
+	//inflight 正在刷新的数据，刷新期间也要能读到
+	inflight map[string]any
+	closed   bool
+	//flushMutex 保证同时只有一个刷新
+	flushMutex sync.Mutex
+
+	flushInterval  time.Duration
+	maxDirty       int
+	maxRetries     int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	closeTimeout   time.Duration
+	onFlushError   func(err error, entries map[string]any)
+
+	trigger chan struct{}
+	stop    chan struct{}
+	//cancelLoop 停下来的时候取消后台正在进行的刷新，没刷完的放回脏数据由 Shutdown 接着刷
+	cancelLoop context.CancelFunc
+	done       chan struct{}
+	once       sync.Once
+}
+
+type WriteBackCacheOption func(c *WriteBackCache)
+
+// WriteBackWithBatchStoreFunc 批量写DB，设置了就不再用 StoreFunc 一个一个写
+func WriteBackWithBatchStoreFunc(fn BatchStoreFunc) WriteBackCacheOption {
+	return func(c *WriteBackCache) {
+		c.batchStore = fn
+	}
+}
+
+// WriteBackWithDeleteFunc 刷新的时候用它把 Delete 掉的key从DB删掉
+func WriteBackWithDeleteFunc(fn DeleteFunc) WriteBackCacheOption {
+	return func(c *WriteBackCache) {
+		c.deleteFunc = fn
+	}
+}
+
+// WriteBackWithFlushInterval 定期刷新的间隔，默认1秒
+func WriteBackWithFlushInterval(interval time.Duration) WriteBackCacheOption {
+	return func(c *WriteBackCache) {
+		c.flushInterval = interval
+	}
 }
 
-func NewWriteBackCache(storeFunc StoreFunc) *WriteBackCache {
-	return &WriteBackCache{
-		StoreFunc: storeFunc,
-		LocalCache: NewLocalCache(func(key string, val any) {
-			//context 和err不好处理
-			err := storeFunc(context.Background(), key, val)
-			if err != nil {
-				log.Fatalln(err)
+// WriteBackWithMaxDirty 脏数据达到这个数量就立刻刷新，不等定时器，0表示只定期刷新
+func WriteBackWithMaxDirty(n int) WriteBackCacheOption {
+	return func(c *WriteBackCache) {
+		c.maxDirty = n
+	}
+}
+
+// WriteBackWithRetry 一次刷新失败之后最多重试几次，退避时间从 initialBackoff 开始翻倍，不超过 maxBackoff
+func WriteBackWithRetry(maxRetries int, initialBackoff, maxBackoff time.Duration) WriteBackCacheOption {
+	return func(c *WriteBackCache) {
+		c.maxRetries = maxRetries
+		c.initialBackoff = initialBackoff
+		c.maxBackoff = maxBackoff
+	}
+}
+
+// WriteBackWithCloseTimeout Close 最多等多久，默认10秒，需要自己控制超时用 Shutdown
+func WriteBackWithCloseTimeout(timeout time.Duration) WriteBackCacheOption {
+	return func(c *WriteBackCache) {
+		c.closeTimeout = timeout
+	}
+}
+
+// WriteBackWithFlushErrorHandler 重试完还是刷新失败的时候回调，这批数据会留到下一轮刷新
+func WriteBackWithFlushErrorHandler(fn func(err error, entries map[string]any)) WriteBackCacheOption {
+	return func(c *WriteBackCache) {
+		c.onFlushError = fn
+	}
+}
+
+func NewWriteBackCache(storeFunc StoreFunc, opts ...WriteBackCacheOption) *WriteBackCache {
+	res := &WriteBackCache{
+		StoreFunc:      storeFunc,
+		LocalCache:     NewLocalCache(nil),
+		dirty:          make(map[string]any),
+		flushInterval:  time.Second,
+		maxRetries:     3,
+		initialBackoff: time.Millisecond * 100,
+		maxBackoff:     time.Second * 5,
+		closeTimeout:   time.Second * 10,
+		trigger:        make(chan struct{}, 1),
+		stop:           make(chan struct{}),
+		done:           make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	res.cancelLoop = cancel
+	go res.flushLoop(ctx)
+	return res
+}
+
+func (c *WriteBackCache) Set(ctx context.Context, key string, val any, expiration time.Duration) error {
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		return ErrCacheClosed
+	}
+	c.dirty[key] = val
+	full := c.maxDirty > 0 && len(c.dirty) >= c.maxDirty
+	//在锁里写缓存，两个并发的 Set 不会出现缓存是一个值、DB是另一个值
+	err := c.LocalCache.Set(ctx, key, val, expiration)
+	c.mutex.Unlock()
+	if full {
+		c.triggerFlush()
+	}
+	return err
+}
+
+func (c *WriteBackCache) Delete(ctx context.Context, key string) error {
+	return c.DeleteMulti(ctx, key)
+}
+
+// DeleteMulti 删除标记和写一样算脏数据
+func (c *WriteBackCache) DeleteMulti(ctx context.Context, keys ...string) error {
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		return ErrCacheClosed
+	}
+	for _, key := range keys {
+		c.dirty[key] = writeBackTombstone{}
+	}
+	full := c.maxDirty > 0 && len(c.dirty) >= c.maxDirty
+	err := c.LocalCache.DeleteMulti(ctx, keys...)
+	c.mutex.Unlock()
+	if full {
+		c.triggerFlush()
+	}
+	return err
+}
+
+func (c *WriteBackCache) triggerFlush() {
+	select {
+	case c.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Get 缓存里没有的时候看看是不是还没刷新的脏数据，脏数据比正在刷新的新，删除标记表示没有这个key
+func (c *WriteBackCache) Get(ctx context.Context, key string) (any, error) {
+	val, err := c.LocalCache.Get(ctx, key)
+	if err == nil {
+		return val, nil
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	val, ok := c.dirty[key]
+	if !ok {
+		val, ok = c.inflight[key]
+	}
+	if !ok {
+		return nil, err
+	}
+	if _, deleted := val.(writeBackTombstone); deleted {
+		return nil, errs.NewErrKeyNotFound(key)
+	}
+	return val, nil
+}
+
+// writeBackTombstone Delete 留在脏数据里的删除标记
+type writeBackTombstone struct{}
+
+// DirtyCount 还没刷新到DB的key的数量
+func (c *WriteBackCache) DirtyCount() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.dirty) + len(c.inflight)
+}
+
+func (c *WriteBackCache) flushLoop(ctx context.Context) {
+	defer close(c.done)
+	ticker := time.NewTicker(c.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.trigger:
+		case <-c.stop:
+			return
+		}
+		//失败的数据已经放回去了，下一轮再刷
+		_ = c.Flush(ctx)
+	}
+}
+
+// Flush 把当前的脏数据批量刷新到DB，失败了按退避重试，重试完还是失败就放回去并返回错误
+func (c *WriteBackCache) Flush(ctx context.Context) error {
+	c.flushMutex.Lock()
+	defer c.flushMutex.Unlock()
+	c.mutex.Lock()
+	entries := c.dirty
+	c.dirty = make(map[string]any)
+	c.inflight = entries
+	c.mutex.Unlock()
+	if len(entries) == 0 {
+		return nil
+	}
+	backoff := c.initialBackoff
+	for i := 0; ; i++ {
+		failed, err := c.store(ctx, entries)
+		if err == nil {
+			c.putBack(nil)
+			return nil
+		}
+		entries = failed
+		if i >= c.maxRetries || ctx.Err() != nil {
+			c.putBack(entries)
+			if c.onFlushError != nil {
+				c.onFlushError(err, entries)
 			}
-		}),
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			c.putBack(entries)
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > c.maxBackoff {
+			backoff = c.maxBackoff
+		}
 	}
 }
 
-func (c *WriteBackCache) Close() error {
-	//遍历所有的key，刷新到数据库
+// store 返回没有写成功的数据，删除标记交给 deleteFunc，没有 deleteFunc 就直接丢掉
+func (c *WriteBackCache) store(ctx context.Context, entries map[string]any) (map[string]any, error) {
+	var (
+		failed  map[string]any
+		lastErr error
+	)
+	fail := func(key string, val any, err error) {
+		if failed == nil {
+			failed = make(map[string]any)
+		}
+		failed[key] = val
+		lastErr = err
+	}
+	writes := make(map[string]any, len(entries))
+	for key, val := range entries {
+		if _, deleted := val.(writeBackTombstone); !deleted {
+			writes[key] = val
+			continue
+		}
+		if c.deleteFunc == nil {
+			continue
+		}
+		if err := c.deleteFunc(ctx, key); err != nil {
+			fail(key, val, err)
+		}
+	}
+	if c.batchStore != nil {
+		if len(writes) == 0 {
+			return failed, lastErr
+		}
+		if err := c.batchStore(ctx, writes); err != nil {
+			for key, val := range writes {
+				fail(key, val, err)
+			}
+		}
+		return failed, lastErr
+	}
+	for key, val := range writes {
+		if err := c.StoreFunc(ctx, key, val); err != nil {
+			fail(key, val, err)
+		}
+	}
+	return failed, lastErr
+}
+
+// putBack 没刷新成功的放回脏数据，刷新期间又被写过的key以新值为准
+func (c *WriteBackCache) putBack(entries map[string]any) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for key, val := range entries {
+		if _, ok := c.dirty[key]; !ok {
+			c.dirty[key] = val
+		}
+	}
+	c.inflight = nil
+}
+
+// Shutdown 停止接收写入，阻塞到所有脏数据都刷新到DB，或者 ctx 超时。
+// StoreFunc 不理会 ctx 卡住了也会按时返回，卡住的那次刷新留在后台，LocalCache 不管怎样都会关掉
+func (c *WriteBackCache) Shutdown(ctx context.Context) error {
+	c.mutex.Lock()
+	c.closed = true
+	c.mutex.Unlock()
+	c.once.Do(func() {
+		close(c.stop)
+		c.cancelLoop()
+	})
+	drained := make(chan error, 1)
+	go func() {
+		<-c.done
+		drained <- c.drain(ctx)
+	}()
+	var err error
+	select {
+	case err = <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	closeErr := c.LocalCache.Close()
+	if err != nil {
+		return fmt.Errorf("cache:还有%d个key没有刷新到DB %w", c.DirtyCount(), err)
+	}
+	return closeErr
+}
+
+// drain 一直刷新到没有脏数据，ctx 超时了返回最后一次刷新的错误
+func (c *WriteBackCache) drain(ctx context.Context) error {
+	for c.DirtyCount() > 0 {
+		err := c.Flush(ctx)
+		if err == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(c.initialBackoff):
+		}
+	}
 	return nil
 }
+
+// Close 遍历所有的脏数据，刷新到数据库，最多等 closeTimeout
+func (c *WriteBackCache) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.closeTimeout)
+	defer cancel()
+	return c.Shutdown(ctx)
+}
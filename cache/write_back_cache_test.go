@@ -0,0 +1,217 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+type mockDB struct {
+	mutex   sync.Mutex
+	data    map[string]any
+	batches int
+	// failTimes 前几次写失败
+	failTimes int
+}
+
+func (m *mockDB) batchStore(ctx context.Context, entries map[string]any) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.failTimes > 0 {
+		m.failTimes--
+		return errors.New("db down")
+	}
+	m.batches++
+	for k, v := range entries {
+		m.data[k] = v
+	}
+	return nil
+}
+
+func (m *mockDB) len() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return len(m.data)
+}
+
+func TestWriteBackCache_MaxDirty(t *testing.T) {
+	db := &mockDB{data: map[string]any{}}
+	c := NewWriteBackCache(nil, WriteBackWithBatchStoreFunc(db.batchStore),
+		WriteBackWithFlushInterval(time.Hour), WriteBackWithMaxDirty(10))
+	defer c.Close()
+	ctx := context.Background()
+	for i := 0; i < 9; i++ {
+		require.NoError(t, c.Set(ctx, "key"+strconv.Itoa(i), i, time.Minute))
+	}
+	time.Sleep(time.Millisecond * 50)
+	assert.Equal(t, 0, db.len())
+	// 攒够10个立刻刷新，而且是一批
+	require.NoError(t, c.Set(ctx, "key9", 9, time.Minute))
+	assert.Eventually(t, func() bool {
+		return db.len() == 10
+	}, time.Second, time.Millisecond*10)
+	assert.Equal(t, 1, db.batches)
+	assert.Equal(t, 0, c.DirtyCount())
+}
+
+func TestWriteBackCache_Retry(t *testing.T) {
+	db := &mockDB{data: map[string]any{}, failTimes: 2}
+	c := NewWriteBackCache(nil, WriteBackWithBatchStoreFunc(db.batchStore),
+		WriteBackWithFlushInterval(time.Hour), WriteBackWithRetry(3, time.Millisecond, time.Millisecond*5))
+	defer c.Close()
+	ctx := context.Background()
+	require.NoError(t, c.Set(ctx, "key1", 1, time.Minute))
+	require.NoError(t, c.Flush(ctx))
+	assert.Equal(t, 1, db.len())
+}
+
+func TestWriteBackCache_StoreFunc(t *testing.T) {
+	var mutex sync.Mutex
+	stored := map[string]any{}
+	c := NewWriteBackCache(func(ctx context.Context, key string, val any) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		stored[key] = val
+		return nil
+	}, WriteBackWithFlushInterval(time.Hour))
+	ctx := context.Background()
+	require.NoError(t, c.Set(ctx, "key1", 1, time.Minute))
+	require.NoError(t, c.Set(ctx, "key2", 2, time.Minute))
+	// 还没刷新也能读到
+	val, err := c.Get(ctx, "key1")
+	require.NoError(t, err)
+	assert.Equal(t, 1, val)
+	// Close 会把脏数据全部刷新完
+	require.NoError(t, c.Close())
+	assert.Equal(t, map[string]any{"key1": 1, "key2": 2}, stored)
+	assert.Equal(t, ErrCacheClosed, c.Set(ctx, "key3", 3, time.Minute))
+}
+
+func TestWriteBackCache_Delete(t *testing.T) {
+	testCases := []struct {
+		name string
+		// deleteFunc 为true的时候设置 DeleteFunc
+		deleteFunc bool
+		wantDB     map[string]any
+		wantDel    []string
+	}{
+		{
+			// 只丢掉还没刷新的写，DB里已有的不动
+			name:   "no delete func",
+			wantDB: map[string]any{"key2": 2, "old": "old"},
+		},
+		{
+			name:       "delete func",
+			deleteFunc: true,
+			wantDB:     map[string]any{"key2": 2},
+			wantDel:    []string{"key1", "old"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db := &mockDB{data: map[string]any{"old": "old"}}
+			var deleted []string
+			opts := []WriteBackCacheOption{WriteBackWithBatchStoreFunc(db.batchStore), WriteBackWithFlushInterval(time.Hour)}
+			if tc.deleteFunc {
+				opts = append(opts, WriteBackWithDeleteFunc(func(ctx context.Context, key string) error {
+					db.mutex.Lock()
+					defer db.mutex.Unlock()
+					delete(db.data, key)
+					deleted = append(deleted, key)
+					return nil
+				}))
+			}
+			c := NewWriteBackCache(nil, opts...)
+			ctx := context.Background()
+			require.NoError(t, c.Set(ctx, "key1", 1, time.Minute))
+			require.NoError(t, c.Set(ctx, "key2", 2, time.Minute))
+			require.NoError(t, c.Delete(ctx, "key1"))
+			require.NoError(t, c.DeleteMulti(ctx, "old"))
+			// 还没刷新也读不到删掉的值
+			_, err := c.Get(ctx, "key1")
+			assert.True(t, IsKeyNotFound(err))
+			require.NoError(t, c.Close())
+			assert.Equal(t, tc.wantDB, db.data)
+			assert.ElementsMatch(t, tc.wantDel, deleted)
+		})
+	}
+}
+
+func TestWriteBackCache_ConcurrentSet(t *testing.T) {
+	db := &mockDB{data: map[string]any{}}
+	c := NewWriteBackCache(nil, WriteBackWithBatchStoreFunc(db.batchStore), WriteBackWithFlushInterval(time.Hour))
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.NoError(t, c.Set(ctx, "key1", i, time.Minute))
+		}(i)
+	}
+	wg.Wait()
+	require.NoError(t, c.Flush(ctx))
+	// 缓存和DB最后是同一个值
+	val, err := c.Get(ctx, "key1")
+	require.NoError(t, err)
+	assert.Equal(t, db.data["key1"], val)
+	require.NoError(t, c.Close())
+}
+
+func TestWriteBackCache_ShutdownTimeout(t *testing.T) {
+	db := &mockDB{data: map[string]any{}, failTimes: 1 << 30}
+	var (
+		mutex    sync.Mutex
+		flushErr error
+	)
+	c := NewWriteBackCache(nil, WriteBackWithBatchStoreFunc(db.batchStore),
+		WriteBackWithFlushInterval(time.Hour), WriteBackWithRetry(1, time.Millisecond, time.Millisecond),
+		WriteBackWithFlushErrorHandler(func(err error, entries map[string]any) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			flushErr = err
+		}))
+	ctx := context.Background()
+	require.NoError(t, c.Set(ctx, "key1", 1, time.Minute))
+	ctx, cancel := context.WithTimeout(ctx, time.Millisecond*100)
+	defer cancel()
+	err := c.Shutdown(ctx)
+	assert.Error(t, err)
+	assert.Eventually(t, func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return flushErr != nil
+	}, time.Second, time.Millisecond*10)
+	assert.Equal(t, 1, c.DirtyCount())
+}
+
+func TestWriteBackCache_ShutdownStuckStore(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	started := make(chan struct{}, 1)
+	// StoreFunc 不理会 ctx，一直卡着
+	c := NewWriteBackCache(func(ctx context.Context, key string, val any) error {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-block
+		return nil
+	}, WriteBackWithFlushInterval(time.Hour), WriteBackWithMaxDirty(1))
+	ctx := context.Background()
+	require.NoError(t, c.Set(ctx, "key1", 1, time.Minute))
+	// 后台刷新卡在写DB上
+	<-started
+	ctx, cancel := context.WithTimeout(ctx, time.Millisecond*100)
+	defer cancel()
+	start := time.Now()
+	err := c.Shutdown(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, 1, c.DirtyCount())
+}
@@ -2,30 +2,312 @@ package cache
 
 import (
 	"context"
+	"fmt"
+	"hash/fnv"
+	"sync"
 	"time"
 )
 
+// WriteMode 写DB和写缓存的同步方式
+type WriteMode int
+
+const (
+	// WriteModeSync 同步：先写DB再写缓存，都成功了才返回
+	WriteModeSync WriteMode = iota
+	// WriteModeSemiAsync 半异步：同步写DB，缓存放到队列里由后台写，Set 返回的时候DB已经写好了，
+	// 缓存稍后才更新，刚写完马上读可能读到旧值
+	WriteModeSemiAsync
+	// WriteModeAsync 全异步：DB和缓存都放到队列里，后台先写DB再写缓存，Set 入队就返回
+	WriteModeAsync
+)
+
 type WriteThroughCache struct {
 	Cache
 	Expiration time.Duration
 	//把捞DB抽象为“加载数据”
 	StoreFunc
+
+	mode       WriteMode
+	workers    int
+	queueSize  int
+	onStoreErr func(ctx context.Context, key string, val any, err error)
+
+	//下面是异步模式用的
+	//closeMutex 保证关闭之后不会再往队列里写
+	closeMutex sync.RWMutex
+	closed     bool
+	//queues 每个worker一个队列，同一个key总是进同一个队列，保证同一个key的写入是有序的
+	queues []chan string
+	//slots 限制排队的key的总数
+	slots chan struct{}
+	//mutex 保护 pending，pending 里的key还没被worker取走，再写同一个key直接覆盖，不用再入队
+	mutex   sync.Mutex
+	pending map[string]writeTask
+	//inflight 被worker取走了还没写完的key，这时候 Delete 要再排一个删除在它后面
+	inflight map[string]struct{}
+	//unfinished 入队了还没写完的key的数量，归零的时候关闭 idle 通知 Flush
+	unfinished int
+	idle       chan struct{}
+	wg         sync.WaitGroup
+}
+
+type writeTask struct {
+	val        any
+	expiration time.Duration
+	//store 全异步的写，后台要写DB
+	store bool
+	//deleted 排队期间被 Delete 了，后台不再写缓存，改成删缓存，DB还是照写
+	deleted bool
+}
+
+type WriteThroughCacheOption func(c *WriteThroughCache)
+
+// WriteThroughWithMode 设置写入方式，默认同步
+func WriteThroughWithMode(mode WriteMode) WriteThroughCacheOption {
+	return func(c *WriteThroughCache) {
+		c.mode = mode
+	}
+}
+
+// WriteThroughWithWorkers 异步模式下后台写入的goroutine数量，默认4
+func WriteThroughWithWorkers(n int) WriteThroughCacheOption {
+	return func(c *WriteThroughCache) {
+		c.workers = n
+	}
+}
+
+// WriteThroughWithQueueSize 异步模式下队列的容量，默认1024，队列满了 Set 会阻塞到有空位，ctx 超时返回 ErrCacheFull
+func WriteThroughWithQueueSize(n int) WriteThroughCacheOption {
+	return func(c *WriteThroughCache) {
+		c.queueSize = n
+	}
 }
 
-func NewWriteThroughCache(cache Cache, Expiration time.Duration, storeFunc StoreFunc) *WriteThroughCache {
-	return &WriteThroughCache{
+// WriteThroughWithOnStoreError 异步模式下后台写入失败的时候回调，半异步是写缓存失败，全异步是写DB或者写缓存失败，
+// 失败了不会重试，需要重试的话在回调里处理
+func WriteThroughWithOnStoreError(fn func(ctx context.Context, key string, val any, err error)) WriteThroughCacheOption {
+	return func(c *WriteThroughCache) {
+		c.onStoreErr = fn
+	}
+}
+
+func NewWriteThroughCache(cache Cache, Expiration time.Duration, storeFunc StoreFunc,
+	opts ...WriteThroughCacheOption) *WriteThroughCache {
+	res := &WriteThroughCache{
 		Cache:      cache,
 		Expiration: Expiration,
 		StoreFunc:  storeFunc,
+		workers:    4,
+		queueSize:  1024,
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	if res.mode != WriteModeSync {
+		res.start()
+	}
+	return res
+}
+
+func (c *WriteThroughCache) start() {
+	if c.workers <= 0 {
+		c.workers = 1
+	}
+	if c.queueSize <= 0 {
+		c.queueSize = 1
+	}
+	c.pending = make(map[string]writeTask)
+	c.inflight = make(map[string]struct{})
+	c.slots = make(chan struct{}, c.queueSize)
+	c.queues = make([]chan string, c.workers)
+	for i := range c.queues {
+		//key的分布不一定均匀，每个队列都要能放下所有位置
+		c.queues[i] = make(chan string, c.queueSize)
+		c.wg.Add(1)
+		go c.work(c.queues[i])
 	}
 }
 
 func (c *WriteThroughCache) Set(ctx context.Context, key string, val any, expiration time.Duration) error {
-	//在这里开goroutine是全异步
-	err := c.StoreFunc(ctx, key, val)
-	if err != nil {
-		return err
+	switch c.mode {
+	case WriteModeSemiAsync:
+		if err := c.StoreFunc(ctx, key, val); err != nil {
+			return err
+		}
+		return c.enqueue(ctx, key, writeTask{val: val, expiration: expiration})
+	case WriteModeAsync:
+		return c.enqueue(ctx, key, writeTask{val: val, expiration: expiration, store: true})
+	default:
+		//在这里开goroutine是全异步
+		err := c.StoreFunc(ctx, key, val)
+		if err != nil {
+			return err
+		}
+		//在这里开goroutine是半异步
+		return c.Cache.Set(ctx, key, val, expiration)
+	}
+}
+
+// Delete 同步删缓存。异步模式下同一个key还在排队的写不能再写回缓存：
+// 还没被取走的改成删缓存，正在写的后面再排一个删除，保证最后缓存里没有这个key
+func (c *WriteThroughCache) Delete(ctx context.Context, key string) error {
+	if c.mode != WriteModeSync {
+		if err := c.cancelWrite(ctx, key); err != nil {
+			return err
+		}
+	}
+	return c.Cache.Delete(ctx, key)
+}
+
+func (c *WriteThroughCache) cancelWrite(ctx context.Context, key string) error {
+	c.mutex.Lock()
+	if task, ok := c.pending[key]; ok {
+		task.deleted = true
+		c.pending[key] = task
+		c.mutex.Unlock()
+		return nil
+	}
+	_, ok := c.inflight[key]
+	c.mutex.Unlock()
+	if !ok {
+		return nil
+	}
+	err := c.enqueue(ctx, key, writeTask{deleted: true})
+	//关闭的时候 worker 会把正在写的写完，没法再排删除，缓存同步删掉就算了
+	if err == ErrCacheClosed {
+		return nil
+	}
+	return err
+}
+
+// enqueue 同一个key还在排队的话直接覆盖成新值，只有最后一次写会落到后台
+func (c *WriteThroughCache) enqueue(ctx context.Context, key string, task writeTask) error {
+	c.closeMutex.RLock()
+	defer c.closeMutex.RUnlock()
+	if c.closed {
+		return ErrCacheClosed
+	}
+	if c.coalesce(key, task) {
+		return nil
+	}
+	//先占一个位置，占不到就一直等，等的时候不能拿着锁
+	select {
+	case c.slots <- struct{}{}:
+	case <-ctx.Done():
+		return fmt.Errorf("%w: %w", ErrCacheFull, ctx.Err())
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	//等位置的时候别人可能已经把这个key放进去了
+	if _, ok := c.pending[key]; ok {
+		c.pending[key] = task
+		<-c.slots
+		return nil
+	}
+	c.pending[key] = task
+	if c.unfinished == 0 {
+		c.idle = make(chan struct{})
+	}
+	c.unfinished++
+	//占到了位置，队列一定放得下
+	c.queues[c.shard(key)] <- key
+	return nil
+}
+
+func (c *WriteThroughCache) coalesce(key string, task writeTask) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, ok := c.pending[key]; ok {
+		c.pending[key] = task
+		return true
+	}
+	return false
+}
+
+func (c *WriteThroughCache) shard(key string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(c.queues)))
+}
+
+func (c *WriteThroughCache) work(queue chan string) {
+	defer c.wg.Done()
+	for key := range queue {
+		c.mutex.Lock()
+		task := c.pending[key]
+		delete(c.pending, key)
+		c.inflight[key] = struct{}{}
+		c.mutex.Unlock()
+		<-c.slots
+		c.write(key, task)
+		c.done(key)
+	}
+}
+
+// write 请求的ctx可能早就取消了，后台写不能用它。半异步的DB在 Set 里已经写过了，这里只写缓存
+func (c *WriteThroughCache) write(key string, task writeTask) {
+	ctx := context.Background()
+	var err error
+	if task.store {
+		err = c.StoreFunc(ctx, key, task.val)
+	}
+	if err == nil {
+		if task.deleted {
+			err = c.Cache.Delete(ctx, key)
+		} else {
+			err = c.Cache.Set(ctx, key, task.val, task.expiration)
+		}
+	}
+	if err != nil && c.onStoreErr != nil {
+		c.onStoreErr(ctx, key, task.val, err)
+	}
+}
+
+func (c *WriteThroughCache) done(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.inflight, key)
+	c.unfinished--
+	if c.unfinished == 0 {
+		close(c.idle)
+	}
+}
+
+// Flush 阻塞到当前队列里的写全部完成，或者 ctx 超时。同步模式直接返回
+func (c *WriteThroughCache) Flush(ctx context.Context) error {
+	if c.mode == WriteModeSync {
+		return nil
+	}
+	c.mutex.Lock()
+	if c.unfinished == 0 {
+		c.mutex.Unlock()
+		return nil
+	}
+	idle := c.idle
+	c.mutex.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close 不再接收新的写入，阻塞到队列里的写全部完成
+func (c *WriteThroughCache) Close() error {
+	if c.mode == WriteModeSync {
+		return nil
+	}
+	c.closeMutex.Lock()
+	if c.closed {
+		c.closeMutex.Unlock()
+		return nil
+	}
+	c.closed = true
+	for _, queue := range c.queues {
+		close(queue)
 	}
-	//在这里开goroutine是半异步
-	return c.Cache.Set(ctx, key, val, expiration)
+	c.closeMutex.Unlock()
+	c.wg.Wait()
+	return nil
 }
@@ -0,0 +1,214 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+type storeRecorder struct {
+	mutex sync.Mutex
+	data  map[string]any
+	calls int
+	// block 不为nil的时候每次写都要等它
+	block chan struct{}
+	err   error
+}
+
+func (s *storeRecorder) store(ctx context.Context, key string, val any) error {
+	if s.block != nil {
+		<-s.block
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.calls++
+	if s.err != nil {
+		return s.err
+	}
+	s.data[key] = val
+	return nil
+}
+
+func (s *storeRecorder) get(key string) (any, int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.data[key], s.calls
+}
+
+func TestWriteThroughCache_Modes(t *testing.T) {
+	testCases := []struct {
+		name string
+		mode WriteMode
+		// 刚 Set 完DB里有没有
+		storedAfterSet bool
+	}{
+		{name: "sync", mode: WriteModeSync, storedAfterSet: true},
+		{name: "semi async", mode: WriteModeSemiAsync, storedAfterSet: true},
+		{name: "async", mode: WriteModeAsync, storedAfterSet: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db := &storeRecorder{data: map[string]any{}, block: make(chan struct{})}
+			if tc.mode != WriteModeAsync {
+				close(db.block)
+			}
+			local := NewBuildinMapCache()
+			defer local.Close()
+			c := NewWriteThroughCache(local, time.Minute, db.store, WriteThroughWithMode(tc.mode))
+			ctx := context.Background()
+			require.NoError(t, c.Set(ctx, "key1", 1, time.Minute))
+			_, calls := db.get("key1")
+			assert.Equal(t, tc.storedAfterSet, calls == 1)
+			if tc.mode == WriteModeAsync {
+				// 全异步先写DB再写缓存，DB没写完缓存里也没有
+				_, err := local.Get(ctx, "key1")
+				assert.True(t, IsKeyNotFound(err))
+				close(db.block)
+			}
+			require.NoError(t, c.Flush(ctx))
+			val, calls := db.get("key1")
+			assert.Equal(t, 1, val)
+			assert.Equal(t, 1, calls)
+			val, err := local.Get(ctx, "key1")
+			require.NoError(t, err)
+			assert.Equal(t, 1, val)
+			require.NoError(t, c.Close())
+		})
+	}
+}
+
+func TestWriteThroughCache_Delete(t *testing.T) {
+	testCases := []struct {
+		name string
+		mode WriteMode
+		// 先写一个别的key把唯一的worker占住，要删的key还在排队；否则要删的key正在写
+		queued bool
+	}{
+		{name: "async queued", mode: WriteModeAsync, queued: true},
+		{name: "async inflight", mode: WriteModeAsync},
+		{name: "semi async queued", mode: WriteModeSemiAsync, queued: true},
+		{name: "semi async inflight", mode: WriteModeSemiAsync},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			block := make(chan struct{})
+			local := NewBuildinMapCache()
+			defer local.Close()
+			// 半异步同步写DB，卡住的是后台写缓存
+			blocked := &blockingCache{Cache: local, block: block}
+			db := &storeRecorder{data: map[string]any{}}
+			if tc.mode == WriteModeAsync {
+				db.block = block
+			} else if tc.queued {
+				blocked.keys = map[string]bool{"key0": true}
+			} else {
+				blocked.keys = map[string]bool{"key1": true}
+			}
+			c := NewWriteThroughCache(blocked, time.Minute, db.store,
+				WriteThroughWithMode(tc.mode), WriteThroughWithWorkers(1))
+			ctx := context.Background()
+			if tc.queued {
+				require.NoError(t, c.Set(ctx, "key0", 0, time.Minute))
+			}
+			require.NoError(t, c.Set(ctx, "key1", 1, time.Minute))
+			// 等 worker 把第一个取走
+			time.Sleep(time.Millisecond * 20)
+			require.NoError(t, c.Delete(ctx, "key1"))
+			close(block)
+			require.NoError(t, c.Flush(ctx))
+			_, err := local.Get(ctx, "key1")
+			assert.True(t, IsKeyNotFound(err))
+			// Delete 只管缓存，DB照样写
+			val, _ := db.get("key1")
+			assert.Equal(t, 1, val)
+			require.NoError(t, c.Close())
+		})
+	}
+}
+
+// blockingCache keys 为nil的时候所有的 Set 都要等 block，否则只有 keys 里的key要等
+type blockingCache struct {
+	Cache
+	block chan struct{}
+	keys  map[string]bool
+}
+
+func (b *blockingCache) Set(ctx context.Context, key string, val any, expiration time.Duration) error {
+	if b.keys == nil || b.keys[key] {
+		<-b.block
+	}
+	return b.Cache.Set(ctx, key, val, expiration)
+}
+
+func TestWriteThroughCache_Coalesce(t *testing.T) {
+	db := &storeRecorder{data: map[string]any{}, block: make(chan struct{})}
+	c := NewWriteThroughCache(NewLocalCache(nil), time.Minute, db.store,
+		WriteThroughWithMode(WriteModeAsync), WriteThroughWithWorkers(1))
+	ctx := context.Background()
+	// 第一个被worker取走了，卡在写DB上
+	require.NoError(t, c.Set(ctx, "key0", 0, time.Minute))
+	time.Sleep(time.Millisecond * 20)
+	// 后面同一个key写了10次，只会写一次DB
+	for i := 1; i <= 10; i++ {
+		require.NoError(t, c.Set(ctx, "key1", i, time.Minute))
+	}
+	close(db.block)
+	require.NoError(t, c.Close())
+	val, calls := db.get("key1")
+	assert.Equal(t, 10, val)
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, ErrCacheClosed, c.Set(ctx, "key1", 11, time.Minute))
+}
+
+func TestWriteThroughCache_QueueFull(t *testing.T) {
+	db := &storeRecorder{data: map[string]any{}, block: make(chan struct{})}
+	c := NewWriteThroughCache(NewLocalCache(nil), time.Minute, db.store,
+		WriteThroughWithMode(WriteModeAsync), WriteThroughWithWorkers(1), WriteThroughWithQueueSize(2))
+	ctx := context.Background()
+	// 一个在写，两个在排队
+	for i := 0; i < 3; i++ {
+		require.NoError(t, c.Set(ctx, "key"+strconv.Itoa(i), i, time.Minute))
+		time.Sleep(time.Millisecond * 10)
+	}
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Millisecond*50)
+	defer cancel()
+	err := c.Set(timeoutCtx, "key3", 3, time.Minute)
+	assert.ErrorIs(t, err, ErrCacheFull)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	// 排队中的key还能合并
+	require.NoError(t, c.Set(timeoutCtx, "key2", 20, time.Minute))
+	assert.Equal(t, context.DeadlineExceeded, c.Flush(timeoutCtx))
+	close(db.block)
+	require.NoError(t, c.Flush(ctx))
+	val, calls := db.get("key2")
+	assert.Equal(t, 20, val)
+	assert.Equal(t, 3, calls)
+	require.NoError(t, c.Close())
+}
+
+func TestWriteThroughCache_OnStoreError(t *testing.T) {
+	db := &storeRecorder{data: map[string]any{}, err: errors.New("db down")}
+	var (
+		mutex  sync.Mutex
+		failed []string
+	)
+	local := NewLocalCache(nil)
+	c := NewWriteThroughCache(local, time.Minute, db.store, WriteThroughWithMode(WriteModeAsync),
+		WriteThroughWithOnStoreError(func(ctx context.Context, key string, val any, err error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			failed = append(failed, key)
+		}))
+	ctx := context.Background()
+	require.NoError(t, c.Set(ctx, "key1", 1, time.Minute))
+	require.NoError(t, c.Close())
+	assert.Equal(t, []string{"key1"}, failed)
+	// 写DB失败了，缓存也不会写
+	_, err := local.Get(ctx, "key1")
+	assert.Error(t, err)
+}
@@ -0,0 +1,205 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// WTinyLFU 参考 Caffeine 的 W-TinyLFU 淘汰策略，适合有大量一次性扫描流量的场景。
+// 新key先进容量1%的窗口LRU，窗口满了之后被挤出来的候选者要和主区域的淘汰者比访问频率，
+// 频率更高的才能进主区域，这样扫描流量只会在窗口里打转，不会把热点冲掉。
+// 主区域是分段LRU：试用区（20%）和保护区（80%），试用区里再次被访问的key晋升到保护区。
+// 访问频率用 count-min sketch 估算。
+// 容量由缓存的 WithMaxEntries 决定：NewBuildinMapCache(WithMaxEntries(n), WithEvictionPolicy(WTinyLFU()))
+func WTinyLFU() EvictionPolicy {
+	return &wTinyLFUPolicy{
+		window:    list.New(),
+		probation: list.New(),
+		protected: list.New(),
+		nodes:     make(map[string]*list.Element),
+	}
+}
+
+// capacityAware 需要知道缓存容量的淘汰策略，缓存初始化的时候会告诉它
+type capacityAware interface {
+	setCapacity(capacity int)
+}
+
+// setPolicyCapacity 策略需要容量就设置一下，设置容量要在策略开始用之前
+func setPolicyCapacity(policy EvictionPolicy, capacity int) {
+	if p, ok := policy.(capacityAware); ok && capacity > 0 {
+		p.setCapacity(capacity)
+	}
+}
+
+type segment uint8
+
+const (
+	segmentWindow segment = iota
+	segmentProbation
+	segmentProtected
+)
+
+type wTinyLFUEntry struct {
+	key string
+	seg segment
+}
+
+type wTinyLFUPolicy struct {
+	mutex        sync.Mutex
+	window       *list.List
+	probation    *list.List
+	protected    *list.List
+	nodes        map[string]*list.Element
+	windowCap    int
+	mainCap      int
+	protectedCap int
+	sketch       *countMinSketch
+}
+
+func (p *wTinyLFUPolicy) newEmpty() EvictionPolicy {
+	return WTinyLFU()
+}
+
+func (p *wTinyLFUPolicy) setCapacity(capacity int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.resize(capacity)
+}
+
+func (p *wTinyLFUPolicy) resize(capacity int) {
+	p.windowCap = capacity / 100
+	if p.windowCap < 1 {
+		p.windowCap = 1
+	}
+	p.mainCap = capacity - p.windowCap
+	p.protectedCap = p.mainCap * 8 / 10
+	p.sketch = newCountMinSketch(capacity)
+}
+
+func (p *wTinyLFUPolicy) KeyAdded(key string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.init()
+	p.sketch.Increment(key)
+	if node, ok := p.nodes[key]; ok {
+		p.access(node)
+		return
+	}
+	p.nodes[key] = p.window.PushFront(&wTinyLFUEntry{key: key, seg: segmentWindow})
+}
+
+func (p *wTinyLFUPolicy) KeyAccessed(key string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.init()
+	p.sketch.Increment(key)
+	if node, ok := p.nodes[key]; ok {
+		p.access(node)
+	}
+}
+
+func (p *wTinyLFUPolicy) KeyRemoved(key string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	node, ok := p.nodes[key]
+	if !ok {
+		return
+	}
+	p.list(node).Remove(node)
+	delete(p.nodes, key)
+}
+
+// Evict 缓存超过容量的时候调用。窗口超了就让窗口的候选者和试用区的淘汰者比频率，
+// 输的那个被淘汰；窗口没超说明是主区域超了，直接淘汰试用区的队尾
+func (p *wTinyLFUPolicy) Evict() (string, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.init()
+	for p.window.Len() > p.windowCap {
+		candidate := p.window.Back()
+		if p.probation.Len()+p.protected.Len() < p.mainCap {
+			//主区域还有空位，候选者直接进试用区
+			p.moveTo(candidate, segmentProbation)
+			continue
+		}
+		victim := p.probation.Back()
+		if victim == nil {
+			victim = p.protected.Back()
+		}
+		if victim == nil {
+			break
+		}
+		candidateKey := candidate.Value.(*wTinyLFUEntry).key
+		victimKey := victim.Value.(*wTinyLFUEntry).key
+		//频率相同的时候保留老的，防止扫描流量挤进来
+		if p.sketch.Estimate(candidateKey) > p.sketch.Estimate(victimKey) {
+			p.remove(victim)
+			p.moveTo(candidate, segmentProbation)
+			return victimKey, true
+		}
+		p.remove(candidate)
+		return candidateKey, true
+	}
+	for _, l := range []*list.List{p.probation, p.protected, p.window} {
+		if node := l.Back(); node != nil {
+			p.remove(node)
+			return node.Value.(*wTinyLFUEntry).key, true
+		}
+	}
+	return "", false
+}
+
+// init 没有设置过容量的时候给一个默认值，保证可以用
+func (p *wTinyLFUPolicy) init() {
+	if p.sketch == nil {
+		p.resize(1024)
+	}
+}
+
+// access 窗口和保护区里的key移到队头，试用区里的key晋升到保护区，保护区满了就把队尾降回试用区
+func (p *wTinyLFUPolicy) access(node *list.Element) {
+	entry := node.Value.(*wTinyLFUEntry)
+	switch entry.seg {
+	case segmentWindow:
+		p.window.MoveToFront(node)
+	case segmentProtected:
+		p.protected.MoveToFront(node)
+	case segmentProbation:
+		p.moveTo(node, segmentProtected)
+		for p.protected.Len() > p.protectedCap {
+			p.moveTo(p.protected.Back(), segmentProbation)
+		}
+	}
+}
+
+func (p *wTinyLFUPolicy) moveTo(node *list.Element, seg segment) {
+	entry := node.Value.(*wTinyLFUEntry)
+	p.list(node).Remove(node)
+	entry.seg = seg
+	switch seg {
+	case segmentWindow:
+		p.nodes[entry.key] = p.window.PushFront(entry)
+	case segmentProbation:
+		p.nodes[entry.key] = p.probation.PushFront(entry)
+	case segmentProtected:
+		p.nodes[entry.key] = p.protected.PushFront(entry)
+	}
+}
+
+func (p *wTinyLFUPolicy) remove(node *list.Element) {
+	entry := node.Value.(*wTinyLFUEntry)
+	p.list(node).Remove(node)
+	delete(p.nodes, entry.key)
+}
+
+func (p *wTinyLFUPolicy) list(node *list.Element) *list.List {
+	switch node.Value.(*wTinyLFUEntry).seg {
+	case segmentWindow:
+		return p.window
+	case segmentProbation:
+		return p.probation
+	default:
+		return p.protected
+	}
+}
@@ -0,0 +1,73 @@
+package cache
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCountMinSketch_Estimate(t *testing.T) {
+	s := newCountMinSketch(100)
+	for i := 0; i < 5; i++ {
+		s.Increment("hot")
+	}
+	s.Increment("cold")
+	assert.Equal(t, uint8(5), s.Estimate("hot"))
+	assert.Equal(t, uint8(1), s.Estimate("cold"))
+	assert.Equal(t, uint8(0), s.Estimate("none"))
+	for i := 0; i < 100; i++ {
+		s.Increment("hot")
+	}
+	// 计数器有上限
+	assert.LessOrEqual(t, s.Estimate("hot"), uint8(cmsMaxCount))
+	s.reset()
+	assert.LessOrEqual(t, s.Estimate("hot"), uint8(cmsMaxCount/2))
+}
+
+// TestWTinyLFU_ScanResistance 热点key的访问里混着大量一次性的扫描，
+// LRU 的热点会被扫描冲掉，W-TinyLFU 能保住绝大部分热点
+func TestWTinyLFU_ScanResistance(t *testing.T) {
+	testCases := []struct {
+		name    string
+		policy  EvictionPolicy
+		wantHit func(t *testing.T, hit int)
+	}{
+		{
+			name:   "lru",
+			policy: LRU(),
+			wantHit: func(t *testing.T, hit int) {
+				assert.Less(t, hit, 100)
+			},
+		},
+		{
+			name:   "w-tinylfu",
+			policy: WTinyLFU(),
+			wantHit: func(t *testing.T, hit int) {
+				assert.Greater(t, hit, 700)
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			c := NewBuildinMapCache(WithMaxEntries(100), WithEvictionPolicy(tc.policy))
+			hit := 0
+			for i := 0; i < 10000; i++ {
+				_ = c.Set(ctx, "scan"+strconv.Itoa(i), i, time.Minute)
+				if i%10 != 0 {
+					continue
+				}
+				key := "hot" + strconv.Itoa(i/10%50)
+				if _, err := c.Get(ctx, key); err == nil {
+					hit++
+					continue
+				}
+				_ = c.Set(ctx, key, i, time.Minute)
+			}
+			tc.wantHit(t, hit)
+			assert.LessOrEqual(t, len(c.data), 100)
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"golang.org/x/sync/singleflight"
+	"log"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// XFetchCache 概率提前过期（XFetch 算法），解决热点key同时过期、同时回源的问题。
+// 缓存里除了值还存了回源花的时间 Delta 和过期时间 Expiry，每次 Get 命中的时候
+// 如果 now - Delta*beta*ln(rand) >= Expiry 就在后台提前刷新一次。越接近过期、回源越慢的key越容易被提前刷新，
+// 不同实例、不同请求在不同的时间点触发，不用像 PreloadCache 那样额外存哨兵key。
+// 缓存里存的是包了一层的值，本地缓存、RedisCache 的 JSONCodec、GobAnyCodec 都能读回来，
+// 没有设置 codec 的 RedisCache 只能存字符串、[]byte、数字这些 go-redis 自己会写的值。
+// 没命中的回源也经过 singleflight，热点key过期的时候一个进程只有一个请求回源
+type XFetchCache struct {
+	ReadThroughCache
+	//beta 大于1更倾向于提前刷新，小于1更倾向于等到过期，默认1
+	beta   float64
+	g      *singleflight.Group
+	random func() float64
+	rtOpts []ReadThroughCacheOption
+}
+
+type XFetchCacheOption func(c *XFetchCache)
+
+// XFetchWithBeta 调整提前刷新的积极程度，默认1
+func XFetchWithBeta(beta float64) XFetchCacheOption {
+	return func(c *XFetchCache) {
+		c.beta = beta
+	}
+}
+
+// XFetchWithReadThroughOptions 负缓存、加载错误回调之类 ReadThroughCache 的选项，
+// 不要和 WithStaleWhileRevalidate 一起用，两个都是提前刷新
+func XFetchWithReadThroughOptions(opts ...ReadThroughCacheOption) XFetchCacheOption {
+	return func(c *XFetchCache) {
+		c.rtOpts = append(c.rtOpts, opts...)
+	}
+}
+
+// NewXFetchCache cache 是用 GobCodec、BytesCodec、ProtoCodec 的 RedisCache 的时候返回 ErrCacheCodec，
+// 这几种解码不到 any，xfetchItem 存进去读不回来。包在别的装饰器里面的 RedisCache 检查不到，
+// 写缓存失败会交给 WithOnLoadError 的回调，没有设置的话打日志
+func NewXFetchCache(cache Cache, expiration time.Duration, loadFunc LoadFunc, opts ...XFetchCacheOption) (*XFetchCache, error) {
+	if rc, ok := cache.(*RedisCache); ok {
+		switch rc.codec.(type) {
+		case GobCodec, BytesCodec, *ProtoCodec:
+			return nil, fmt.Errorf("%w: XFetchCache 不能用 %T 的 RedisCache，换成 JSONCodec 或者 GobAnyCodec", ErrCacheCodec, rc.codec)
+		}
+	}
+	res := &XFetchCache{
+		beta:   1,
+		g:      &singleflight.Group{},
+		random: rand.Float64,
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	res.ReadThroughCache = *NewReadThroughCache(cache, expiration, loadFunc, res.rtOpts...)
+	//单个回源和 GetMulti 批量回源写缓存的时候都记下回源时间和过期时间
+	res.wrapper = func(val any, loadTime time.Duration) any {
+		return &xfetchItem{Val: val, Delta: loadTime, Expiry: time.Now().Add(res.Expiration)}
+	}
+	if res.onError == nil {
+		res.onError = func(ctx context.Context, key string, err error) {
+			log.Printf("cache: XFetchCache 后台刷新或者写缓存失败 %s: %v", key, err)
+		}
+	}
+	return res, nil
+}
+
+func (c *XFetchCache) Get(ctx context.Context, key string) (any, error) {
+	val, err := c.Cache.Get(ctx, key)
+	if err == nil {
+		c.statsRecorder().RecordHits(1)
+		//用户直接 Set 进来的值没有包装，不会提前刷新
+		if itm, ok := decodeXFetchItem(val); ok {
+			return c.fetch(key, itm), nil
+		}
+		return c.unwrap(key, val)
+	}
+	if !IsKeyNotFound(err) {
+		return nil, err
+	}
+	c.statsRecorder().RecordMisses(1)
+	//和后台刷新共用一个 singleflight，正在刷新的话直接等它的结果
+	val, err, _ = c.g.Do(key, func() (any, error) {
+		return c.load(ctx, key)
+	})
+	return val, err
+}
+
+// GetMulti 批量回源的值回源时间按整批算，之后命中的时候一样会提前刷新
+func (c *XFetchCache) GetMulti(ctx context.Context, keys []string) (map[string]any, error) {
+	res, err := c.ReadThroughCache.GetMulti(ctx, keys)
+	for key, val := range res {
+		if itm, ok := decodeXFetchItem(val); ok {
+			res[key] = c.fetch(key, itm)
+		}
+	}
+	return res, err
+}
+
+// fetch 按概率决定要不要在后台刷新，总是返回现在的值
+func (c *XFetchCache) fetch(key string, itm *xfetchItem) any {
+	if c.shouldRefresh(itm, time.Now()) {
+		c.g.DoChan(key, func() (any, error) {
+			//请求的ctx可能马上就取消了，后台刷新不能用它
+			ctx := context.Background()
+			val, err := c.load(ctx, key)
+			if err != nil {
+				c.handleErr(ctx, key, err)
+			}
+			return val, err
+		})
+	}
+	return itm.Val
+}
+
+// shouldRefresh rand 取 (0, 1]，ln(rand) 小于等于0，所以 gap 是一个非负的随机提前量。
+// 用浮点数比较，gap 很大的时候转成 time.Duration 会溢出
+func (c *XFetchCache) shouldRefresh(itm *xfetchItem, now time.Time) bool {
+	gap := -float64(itm.Delta) * c.beta * math.Log(1-c.random())
+	return gap >= float64(itm.Expiry.Sub(now))
+}
+
+func init() {
+	gob.Register(&xfetchItem{})
+}
+
+// xfetchItem XFetchCache 缓存里实际存的值，和 staleItem 一样要让各种底层缓存都能读回来：
+// 本地缓存和 GobAnyCodec 原样读回来；JSONCodec 读回来是 map[string]any，靠 json tag 认出来；
+// 没有 codec 的 RedisCache 由 go-redis 调 MarshalBinary 写成带前缀的字符串
+type xfetchItem struct {
+	Val    any           `json:"__xfetch_val"`
+	Delta  time.Duration `json:"__xfetch_delta"`
+	Expiry time.Time     `json:"__xfetch_expiry"`
+}
+
+// xfetchItemPrefix 没有 codec 的时候 xfetchItem 写成 前缀+Delta纳秒数+:+Expiry纳秒数+:+值
+const xfetchItemPrefix = "\x00cache:xfetch\x00"
+
+func (x *xfetchItem) MarshalBinary() ([]byte, error) {
+	val, err := redisArgString(x.Val)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(xfetchItemPrefix + strconv.FormatInt(int64(x.Delta), 10) + ":" +
+		strconv.FormatInt(x.Expiry.UnixNano(), 10) + ":" + val), nil
+}
+
+// xfetchItemGob gob 会优先用 MarshalBinary，GobEncode 换成一个没有方法的类型按字段编码
+type xfetchItemGob struct {
+	Val    any
+	Delta  time.Duration
+	Expiry time.Time
+}
+
+func (x *xfetchItem) GobEncode() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(xfetchItemGob(*x)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (x *xfetchItem) GobDecode(data []byte) error {
+	var itm xfetchItemGob
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&itm); err != nil {
+		return err
+	}
+	*x = xfetchItem(itm)
+	return nil
+}
+
+// decodeXFetchItem 认出各种底层缓存读回来的 xfetchItem
+func decodeXFetchItem(val any) (*xfetchItem, bool) {
+	switch v := val.(type) {
+	case *xfetchItem:
+		return v, true
+	case map[string]any:
+		raw, ok := v["__xfetch_val"]
+		delta, ok2 := v["__xfetch_delta"].(float64)
+		expiry, ok3 := v["__xfetch_expiry"].(string)
+		if !ok || !ok2 || !ok3 || len(v) != 3 {
+			return nil, false
+		}
+		t, err := time.Parse(time.RFC3339Nano, expiry)
+		if err != nil {
+			return nil, false
+		}
+		return &xfetchItem{Val: raw, Delta: time.Duration(delta), Expiry: t}, true
+	case string:
+		rest, ok := strings.CutPrefix(v, xfetchItemPrefix)
+		if !ok {
+			return nil, false
+		}
+		parts := strings.SplitN(rest, ":", 3)
+		if len(parts) != 3 {
+			return nil, false
+		}
+		delta, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		expiry, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		return &xfetchItem{Val: parts[2], Delta: time.Duration(delta), Expiry: time.Unix(0, expiry)}, true
+	default:
+		return nil, false
+	}
+}
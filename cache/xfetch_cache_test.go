@@ -0,0 +1,191 @@
+package cache
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestXFetchCache_shouldRefresh(t *testing.T) {
+	now := time.Now()
+	testCases := []struct {
+		name   string
+		delta  time.Duration
+		left   time.Duration
+		beta   float64
+		random float64
+		want   bool
+	}{
+		{
+			// ln(1) = 0，没到过期时间就不会刷新
+			name: "no gap", delta: time.Second, left: time.Millisecond, beta: 1, random: 0, want: false,
+		},
+		{
+			name: "expired", delta: time.Second, left: -time.Millisecond, beta: 1, random: 0, want: true,
+		},
+		{
+			// -ln(1-0.9) ≈ 2.3，提前量大约 2.3s
+			name: "close to expiry", delta: time.Second, left: time.Second * 2, beta: 1, random: 0.9, want: true,
+		},
+		{
+			name: "far from expiry", delta: time.Second, left: time.Second * 3, beta: 1, random: 0.9, want: false,
+		},
+		{
+			name: "bigger beta", delta: time.Second, left: time.Second * 3, beta: 2, random: 0.9, want: true,
+		},
+		{
+			// 回源很快的key几乎不会提前刷新
+			name: "fast load", delta: time.Microsecond, left: time.Second, beta: 1, random: 0.999, want: false,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := NewXFetchCache(NewLocalCache(nil), time.Minute, nil, XFetchWithBeta(tc.beta))
+			require.NoError(t, err)
+			c.random = func() float64 { return tc.random }
+			itm := &xfetchItem{Delta: tc.delta, Expiry: now.Add(tc.left)}
+			assert.Equal(t, tc.want, c.shouldRefresh(itm, now))
+		})
+	}
+}
+
+func TestXFetchCache_Get(t *testing.T) {
+	var loads int32
+	c, err := NewXFetchCache(NewBuildinMapCache(), time.Millisecond*200, func(ctx context.Context, key string) (any, error) {
+		n := atomic.AddInt32(&loads, 1)
+		time.Sleep(time.Millisecond * 10)
+		return int(n), nil
+	})
+	require.NoError(t, err)
+	var random atomic.Value
+	random.Store(0.0)
+	c.random = func() float64 { return random.Load().(float64) }
+	ctx := context.Background()
+
+	val, err := c.Get(ctx, "key1")
+	require.NoError(t, err)
+	assert.Equal(t, 1, val)
+	val, err = c.Get(ctx, "key1")
+	require.NoError(t, err)
+	assert.Equal(t, 1, val)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&loads))
+
+	// -ln(1e-9) ≈ 20.7，提前量大约 207ms，比剩下的时间长，命中的时候返回旧值，后台刷新
+	random.Store(1 - 1e-9)
+	val, err = c.Get(ctx, "key1")
+	require.NoError(t, err)
+	assert.Equal(t, 1, val)
+	assert.Eventually(t, func() bool {
+		val, err := c.Cache.Get(ctx, "key1")
+		return err == nil && val.(*xfetchItem).Val == 2
+	}, time.Second, time.Millisecond*10)
+
+	random.Store(0.0)
+	vals, err := c.GetMulti(ctx, []string{"key1"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"key1": 2}, vals)
+	assert.Equal(t, uint64(2), c.Stats().Loads)
+}
+
+func TestXFetchCache_MissSingleFlight(t *testing.T) {
+	var loads int32
+	c, err := NewXFetchCache(NewBuildinMapCache(), time.Minute, func(ctx context.Context, key string) (any, error) {
+		atomic.AddInt32(&loads, 1)
+		time.Sleep(time.Millisecond * 50)
+		return "value1", nil
+	})
+	require.NoError(t, err)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			val, err := c.Get(context.Background(), "key1")
+			assert.NoError(t, err)
+			assert.Equal(t, "value1", val)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&loads))
+}
+
+func TestXFetchCache_Redis(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	_, err := NewXFetchCache(NewRedisCache(client, RedisCacheWithCodec(GobCodec{})), time.Minute, nil)
+	assert.ErrorIs(t, err, ErrCacheCodec)
+
+	testCases := []struct {
+		name  string
+		cache Cache
+	}{
+		{name: "no codec", cache: NewRedisCache(client)},
+		{name: "json", cache: NewRedisCache(client, RedisCacheWithCodec(JSONCodec{}))},
+		{name: "gob any", cache: NewRedisCache(client, RedisCacheWithCodec(GobAnyCodec{}))},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mr.FlushAll()
+			var loads int32
+			c, err := NewXFetchCache(tc.cache, time.Minute,
+				func(ctx context.Context, key string) (any, error) {
+					atomic.AddInt32(&loads, 1)
+					return "value1", nil
+				}, XFetchWithReadThroughOptions(WithBatchLoadFunc(func(ctx context.Context, keys []string) (map[string]any, error) {
+					res := make(map[string]any, len(keys))
+					for _, key := range keys {
+						res[key] = "batch"
+					}
+					return res, nil
+				})))
+			require.NoError(t, err)
+			ctx := context.Background()
+			for i := 0; i < 2; i++ {
+				val, err := c.Get(ctx, "key1")
+				require.NoError(t, err)
+				assert.Equal(t, "value1", val)
+			}
+			assert.Equal(t, int32(1), atomic.LoadInt32(&loads))
+			vals, err := c.GetMulti(ctx, []string{"key1", "key2"})
+			require.NoError(t, err)
+			assert.Equal(t, map[string]any{"key1": "value1", "key2": "batch"}, vals)
+
+			// redis 里读回来的还是 xfetchItem，批量回源的也是，都能提前刷新
+			for key, want := range map[string]any{"key1": "value1", "key2": "batch"} {
+				val, err := c.Cache.Get(ctx, key)
+				require.NoError(t, err)
+				itm, ok := decodeXFetchItem(val)
+				require.True(t, ok)
+				assert.Equal(t, want, itm.Val)
+				assert.True(t, itm.Expiry.After(time.Now()))
+			}
+		})
+	}
+}
+
+func TestXFetchCache_StoreError(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	type user struct {
+		Name string
+	}
+	errCh := make(chan error, 1)
+	// 包了一层的 RedisCache 构造的时候检查不到，没有 codec 存不了结构体，写缓存失败要交给回调
+	c, err := NewXFetchCache(NewNamespaceCache(NewRedisCache(client), "ns"), time.Minute,
+		func(ctx context.Context, key string) (any, error) {
+			return user{Name: "Tom"}, nil
+		}, XFetchWithReadThroughOptions(WithOnLoadError(func(ctx context.Context, key string, err error) {
+			errCh <- err
+		})))
+	require.NoError(t, err)
+	val, err := c.Get(context.Background(), "key1")
+	require.NoError(t, err)
+	assert.Equal(t, user{Name: "Tom"}, val)
+	assert.ErrorIs(t, <-errCh, ErrCacheCodec)
+}
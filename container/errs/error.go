@@ -10,10 +10,16 @@ func NewErrInputNil() error {
 	return errors.New("输入为nil")
 }
 
+// 队列满、队列空每次返回同一个错误，调用方可以直接比较或者用 errors.Is
+var (
+	ErrFullQueue  = errors.New("队列满")
+	ErrEmptyQueue = errors.New("队列空")
+)
+
 func NewErrFullQueue() error {
-	return errors.New("队列满")
+	return ErrFullQueue
 }
 
 func NewErrEmptyQueue() error {
-	return errors.New("队列空")
+	return ErrEmptyQueue
 }
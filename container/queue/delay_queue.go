@@ -19,6 +19,7 @@ type DelayQueue[T Delayable] struct {
 	outSignal *Cond
 }
 
+// NewDelayQueue capacity 小于等于0表示不限容量，入队不会阻塞
 func NewDelayQueue[T Delayable](capacity int) *DelayQueue[T] {
 	m := &sync.RWMutex{}
 	return &DelayQueue[T]{
@@ -6,7 +6,7 @@ import (
 	"github.com/xuhaidong1/go-generic-tools/container/errs"
 )
 
-// PriorityQueue 优先队列。容量固定 小顶堆
+// PriorityQueue 优先队列。容量固定 小顶堆，maxsize 小于等于0表示不限容量
 type PriorityQueue[T any] struct {
 	data       []T
 	maxsize    int
@@ -14,8 +14,12 @@ type PriorityQueue[T any] struct {
 }
 
 func NewPriorityQueue[T any](maxsize int, comparator go_generic_tools.Comparator[T]) *PriorityQueue[T] {
+	capacity := maxsize
+	if capacity < 0 {
+		capacity = 0
+	}
 	return &PriorityQueue[T]{
-		data:       make([]T, 0, maxsize),
+		data:       make([]T, 0, capacity),
 		maxsize:    maxsize,
 		comparator: comparator,
 	}
@@ -62,7 +66,7 @@ func (p *PriorityQueue[T]) Peek() (T, error) {
 }
 
 func (p *PriorityQueue[T]) IsFull() bool {
-	return len(p.data) == p.maxsize
+	return p.maxsize > 0 && len(p.data) >= p.maxsize
 }
 
 func (p *PriorityQueue[T]) IsEmpty() bool {
@@ -0,0 +1,222 @@
+package queue
+
+import (
+	"container/list"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+var ErrTimingWheelClosed = errors.New("时间轮已经关闭")
+
+// TimingWheel 分层时间轮，Add 和 Stop 都是 O(1)，适合大量定时任务、而且大部分会被取消的场景（比如缓存过期）。
+// 第0层每个槽是一个 tick，一圈是 tick*wheelSize；超出一圈的放到上一层，上一层每个槽是下一层的一圈，
+// 层数按需增加。时间走到上一层某个槽的时候，把槽里的任务重新放一遍，它们会落到下面的层里。
+// 到期回调最多晚一个 tick，不会提前；回调在时间轮自己的 goroutine 里执行，执行的时候不持有时间轮的锁，
+// 所以回调里可以再 Add 或者 Stop
+type TimingWheel[T any] struct {
+	mutex sync.Mutex
+	tick  int64
+	size  int64
+	//levels 第 i 层每个槽的跨度是 tick*size^i
+	levels []*wheelLevel
+	//current UnixNano，是 tick 的整数倍，比它早的时间都已经处理过了
+	current  int64
+	count    int
+	onExpire func(val T)
+	closed   bool
+	close    chan struct{}
+	done     chan struct{}
+}
+
+type wheelLevel struct {
+	tick    int64
+	buckets []*list.List
+}
+
+// Timer Add 返回的句柄，用来取消
+type Timer[T any] struct {
+	Val        T
+	expiration int64
+	wheel      *TimingWheel[T]
+	//bucket 为nil表示已经到期或者取消了
+	bucket *list.List
+	elem   *list.Element
+}
+
+// NewTimingWheel tick 是精度，wheelSize 是每层的槽数，onExpire 是到期回调
+func NewTimingWheel[T any](tick time.Duration, wheelSize int, onExpire func(val T)) *TimingWheel[T] {
+	if tick <= 0 {
+		tick = time.Millisecond
+	}
+	if wheelSize <= 0 {
+		wheelSize = 64
+	}
+	res := newTimingWheel(int64(tick), int64(wheelSize), time.Now().UnixNano(), onExpire)
+	go res.run(tick)
+	return res
+}
+
+// newTimingWheel 不启动 goroutine，单元测试自己调 advance 推进时间
+func newTimingWheel[T any](tick, size, now int64, onExpire func(val T)) *TimingWheel[T] {
+	res := &TimingWheel[T]{
+		tick:     tick,
+		size:     size,
+		current:  now / tick * tick,
+		onExpire: onExpire,
+		close:    make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	res.levels = []*wheelLevel{res.newLevel(tick)}
+	return res
+}
+
+func (w *TimingWheel[T]) newLevel(tick int64) *wheelLevel {
+	res := &wheelLevel{
+		tick:    tick,
+		buckets: make([]*list.List, w.size),
+	}
+	for i := range res.buckets {
+		res.buckets[i] = list.New()
+	}
+	return res
+}
+
+func (w *TimingWheel[T]) run(tick time.Duration) {
+	defer close(w.done)
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			w.advance(now.UnixNano())
+		case <-w.close:
+			return
+		}
+	}
+}
+
+// Add delay 小于等于0的下一个 tick 就到期
+func (w *TimingWheel[T]) Add(delay time.Duration, val T) (*Timer[T], error) {
+	return w.AddAt(time.Now().Add(delay), val)
+}
+
+func (w *TimingWheel[T]) AddAt(deadline time.Time, val T) (*Timer[T], error) {
+	t := &Timer[T]{
+		Val:        val,
+		expiration: deadline.UnixNano(),
+		wheel:      w,
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return nil, ErrTimingWheelClosed
+	}
+	w.add(t)
+	w.count++
+	return t, nil
+}
+
+// add 找到 expiration 落在哪一层的哪个槽，调用方需要持有锁
+func (w *TimingWheel[T]) add(t *Timer[T]) {
+	//已经过期的放到当前槽里，下一个 tick 就会到期
+	exp := t.expiration
+	if exp < w.current {
+		exp = w.current
+	}
+	for i := 0; ; i++ {
+		if i == len(w.levels) {
+			w.levels = append(w.levels, w.newLevel(w.levels[i-1].tick*w.size))
+		}
+		level := w.levels[i]
+		//current 按这一层的跨度取整，这一层能放下 [start, start+tick*size) 之内的
+		//再往上一层跨度会溢出的话就放在这一层，槽位对不上也没关系，flush 的时候会按真实的过期时间重新放
+		start := w.current / level.tick * level.tick
+		if exp < start+level.tick*w.size || level.tick > math.MaxInt64/w.size/w.size {
+			t.bucket = level.buckets[exp/level.tick%w.size]
+			t.elem = t.bucket.PushBack(t)
+			return
+		}
+	}
+}
+
+// advance 把时间推进到 now，返回之前把到期的任务都回调掉
+func (w *TimingWheel[T]) advance(now int64) {
+	var expired []*Timer[T]
+	w.mutex.Lock()
+	for w.current+w.tick <= now {
+		w.current += w.tick
+		//第0层刚走完的那个槽全部到期
+		expired = w.flush(w.levels[0], w.current-w.tick, expired)
+		//走到上层某个槽的起点了，把这个槽里的任务放到下面去
+		for i := len(w.levels) - 1; i > 0; i-- {
+			if level := w.levels[i]; w.current%level.tick == 0 {
+				expired = w.flush(level, w.current, expired)
+			}
+		}
+	}
+	w.count -= len(expired)
+	w.mutex.Unlock()
+	if w.onExpire == nil {
+		return
+	}
+	for _, t := range expired {
+		w.onExpire(t.Val)
+	}
+}
+
+// flush 把 level 上时间 at 所在的槽清空，重新放一遍，已经到期的追加到 expired 里
+func (w *TimingWheel[T]) flush(level *wheelLevel, at int64, expired []*Timer[T]) []*Timer[T] {
+	idx := at / level.tick % w.size
+	bucket := level.buckets[idx]
+	if bucket.Len() == 0 {
+		return expired
+	}
+	level.buckets[idx] = list.New()
+	for e := bucket.Front(); e != nil; e = e.Next() {
+		t := e.Value.(*Timer[T])
+		t.bucket, t.elem = nil, nil
+		if t.expiration < w.current {
+			expired = append(expired, t)
+			continue
+		}
+		w.add(t)
+	}
+	return expired
+}
+
+// Stop 取消任务，返回 false 表示已经到期或者已经取消过了
+func (t *Timer[T]) Stop() bool {
+	w := t.wheel
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if t.bucket == nil {
+		return false
+	}
+	t.bucket.Remove(t.elem)
+	t.bucket, t.elem = nil, nil
+	w.count--
+	return true
+}
+
+// Len 还没到期也没取消的任务数
+func (w *TimingWheel[T]) Len() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.count
+}
+
+// Close 停掉时间轮，没到期的任务直接丢掉，不会回调，可以重复调用
+func (w *TimingWheel[T]) Close() error {
+	w.mutex.Lock()
+	if w.closed {
+		w.mutex.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.mutex.Unlock()
+	close(w.close)
+	<-w.done
+	return nil
+}
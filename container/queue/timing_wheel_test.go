@@ -0,0 +1,113 @@
+package queue
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTimingWheel_Advance(t *testing.T) {
+	const tick = 10
+	testCases := []struct {
+		name string
+		size int64
+		// maxDelay 越大层数越多
+		maxDelay int64
+	}{
+		{name: "one level", size: 64, maxDelay: 600},
+		{name: "multi levels", size: 4, maxDelay: 100000},
+		{name: "expired", size: 4, maxDelay: 0},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var now int64
+			fired := make(map[int64]int64)
+			w := newTimingWheel[int64](tick, tc.size, 0, func(exp int64) {
+				fired[exp] = now
+			})
+			exps := make([]int64, 0, 1000)
+			for i := 0; i < 1000; i++ {
+				exp := rand.Int63n(tc.maxDelay+1) - 5
+				if _, ok := fired[exp]; ok {
+					continue
+				}
+				fired[exp] = -1
+				exps = append(exps, exp)
+				_, err := w.AddAt(time.Unix(0, exp), exp)
+				require.NoError(t, err)
+			}
+			assert.Equal(t, len(exps), w.Len())
+			for now = tick; now <= tc.maxDelay+tick*2; now += tick {
+				w.advance(now)
+			}
+			assert.Equal(t, 0, w.Len())
+			for _, exp := range exps {
+				// 不会提前，最多晚一个 tick，加进来的时候就过期了的在下一个 tick 到期
+				at, added := fired[exp], exp
+				if added < 0 {
+					added = 0
+				}
+				assert.Greater(t, at, exp)
+				assert.LessOrEqual(t, at, added+tick)
+			}
+		})
+	}
+}
+
+func TestTimingWheel_Stop(t *testing.T) {
+	var fired []int
+	w := newTimingWheel[int](10, 4, 0, func(val int) {
+		fired = append(fired, val)
+	})
+	timers := make([]*Timer[int], 0, 10)
+	for i := 0; i < 10; i++ {
+		timer, err := w.AddAt(time.Unix(0, int64(i*100)), i)
+		require.NoError(t, err)
+		timers = append(timers, timer)
+	}
+	for i := 0; i < 10; i += 2 {
+		assert.True(t, timers[i].Stop())
+		assert.False(t, timers[i].Stop())
+	}
+	assert.Equal(t, 5, w.Len())
+	w.advance(1000)
+	assert.Equal(t, []int{1, 3, 5, 7, 9}, fired)
+	// 已经到期的不能再取消
+	assert.False(t, timers[1].Stop())
+	assert.Equal(t, 0, w.Len())
+}
+
+func TestTimingWheel_Run(t *testing.T) {
+	var (
+		mutex sync.Mutex
+		fired []string
+	)
+	var w *TimingWheel[string]
+	w = NewTimingWheel[string](time.Millisecond*5, 8, func(val string) {
+		mutex.Lock()
+		fired = append(fired, val)
+		mutex.Unlock()
+		// 回调里可以再加任务
+		if val == "first" {
+			_, _ = w.Add(time.Millisecond*10, "second")
+		}
+	})
+	start := time.Now()
+	_, err := w.Add(time.Millisecond*50, "first")
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return len(fired) == 2
+	}, time.Second, time.Millisecond*5)
+	assert.GreaterOrEqual(t, time.Since(start), time.Millisecond*60)
+	assert.Equal(t, []string{"first", "second"}, fired)
+
+	require.NoError(t, w.Close())
+	require.NoError(t, w.Close())
+	_, err = w.Add(time.Millisecond, "third")
+	assert.Equal(t, ErrTimingWheelClosed, err)
+}
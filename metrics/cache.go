@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"github.com/xuhaidong1/go-generic-tools/cache"
+)
+
+// NewCacheCollector 抓取的时候读一次 c.Stats()，name 作为 cache 这个label区分不同的缓存。
+// 输出 cache_hits_total、cache_misses_total、cache_loads_total、cache_load_errors_total、
+// cache_load_duration_seconds_total、cache_evictions_total{reason}、cache_entries
+func NewCacheCollector(name string, c cache.StatsCache) Collector {
+	return CollectorFunc(func() []Family {
+		stats := c.Stats()
+		labels := []Label{{Name: "cache", Value: name}}
+		counter := func(metric, help string, val float64) Family {
+			return Family{Name: metric, Help: help, Type: CounterType,
+				Samples: []Sample{{Labels: labels, Value: val}}}
+		}
+		evictions := Family{Name: "cache_evictions_total", Help: "缓存淘汰的次数，按原因区分", Type: CounterType}
+		for _, reason := range []cache.EvictionReason{
+			cache.EvictionReasonExpired, cache.EvictionReasonCapacity, cache.EvictionReasonDeleted,
+		} {
+			evictions.Samples = append(evictions.Samples, Sample{
+				Labels: withLabel(labels, "reason", reason.String()),
+				Value:  float64(stats.Evictions[reason]),
+			})
+		}
+		return []Family{
+			counter("cache_hits_total", "缓存命中的次数", float64(stats.Hits)),
+			counter("cache_misses_total", "缓存未命中的次数", float64(stats.Misses)),
+			counter("cache_loads_total", "回源成功的次数", float64(stats.Loads)),
+			counter("cache_load_errors_total", "回源失败的次数", float64(stats.LoadErrors)),
+			counter("cache_load_duration_seconds_total", "回源的总耗时", stats.TotalLoadTime.Seconds()),
+			evictions,
+			{Name: "cache_entries", Help: "缓存里当前key的数量", Type: GaugeType,
+				Samples: []Sample{{Labels: labels, Value: float64(stats.Entries)}}},
+		}
+	})
+}
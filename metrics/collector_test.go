@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xuhaidong1/go-generic-tools/cache"
+	"github.com/xuhaidong1/go-generic-tools/container/queue"
+	"github.com/xuhaidong1/go-generic-tools/redis_lock"
+	"strings"
+	"testing"
+	"time"
+)
+
+func gather(t *testing.T, reg *Registry) string {
+	var sb strings.Builder
+	_, err := reg.WriteTo(&sb)
+	require.NoError(t, err)
+	return sb.String()
+}
+
+func TestCacheCollector(t *testing.T) {
+	ctx := context.Background()
+	c := cache.NewBuildinMapCache()
+	defer c.Close()
+	require.NoError(t, c.Set(ctx, "key1", 1, time.Minute))
+	_, err := c.Get(ctx, "key1")
+	require.NoError(t, err)
+	_, err = c.Get(ctx, "key2")
+	require.Error(t, err)
+	require.NoError(t, c.Delete(ctx, "key1"))
+
+	reg := NewRegistry()
+	reg.Register(NewCacheCollector("user", c))
+	out := gather(t, reg)
+	assert.Contains(t, out, `cache_hits_total{cache="user"} 1`)
+	assert.Contains(t, out, `cache_misses_total{cache="user"} 1`)
+	assert.Contains(t, out, `cache_evictions_total{cache="user",reason="deleted"} 1`)
+	assert.Contains(t, out, `cache_evictions_total{cache="user",reason="expired"} 0`)
+	assert.Contains(t, out, `cache_entries{cache="user"} 0`)
+}
+
+func TestInstrumentQueue(t *testing.T) {
+	reg := NewRegistry()
+	q := InstrumentQueue[int](reg, "jobs", queue.NewConcurrentBlockingQueue[int](1))
+	ctx := context.Background()
+	require.NoError(t, q.Enqueue(ctx, 1))
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Millisecond*10)
+	defer cancel()
+	// 满了，等到超时
+	require.Error(t, q.Enqueue(timeoutCtx, 2))
+	out := gather(t, reg)
+	assert.Contains(t, out, `queue_depth{queue="jobs"} 1`)
+	assert.Contains(t, out, `queue_wait_seconds_count{queue="jobs",op="enqueue"} 2`)
+	assert.Contains(t, out, `queue_operation_errors_total{queue="jobs",op="enqueue"} 1`)
+
+	_, err := q.Dequeue(ctx)
+	require.NoError(t, err)
+	out = gather(t, reg)
+	assert.Contains(t, out, `queue_depth{queue="jobs"} 0`)
+	assert.Contains(t, out, `queue_wait_seconds_count{queue="jobs",op="dequeue"} 1`)
+}
+
+func TestLockMetrics(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	reg := NewRegistry()
+	client := redis_lock.NewClient(rdb, redis_lock.WithAcquireObserver(NewLockMetrics(reg, "order").Observe))
+	ctx := context.Background()
+	_, err := client.TryLock(ctx, "lock1", "a", time.Minute)
+	require.NoError(t, err)
+	_, err = client.TryLock(ctx, "lock1", "b", time.Minute)
+	require.Error(t, err)
+	out := gather(t, reg)
+	assert.Contains(t, out, `redis_lock_acquire_duration_seconds_count{client="order",result="acquired"} 1`)
+	assert.Contains(t, out, `redis_lock_acquire_duration_seconds_count{client="order",result="held"} 1`)
+	assert.Contains(t, out, `redis_lock_acquire_failures_total{client="order",reason="held"} 1`)
+}
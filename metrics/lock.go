@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"github.com/xuhaidong1/go-generic-tools/redis_lock/errs"
+	"time"
+)
+
+// LockMetrics 分布式锁的打点，Observe 可以直接传给 redis_lock.WithAcquireObserver。输出：
+// redis_lock_acquire_duration_seconds{result} 抢锁耗时，result 是 acquired、held（锁被别人拿着）、timeout、error；
+// redis_lock_acquire_failures_total{reason} 没抢到锁的次数，reason 同上
+type LockMetrics struct {
+	name     string
+	duration *HistogramVec
+	failures *CounterVec
+}
+
+// NewLockMetrics name 作为 client 这个label区分不同的锁客户端
+func NewLockMetrics(reg *Registry, name string) *LockMetrics {
+	res := &LockMetrics{
+		name:     name,
+		duration: NewHistogramVec("redis_lock_acquire_duration_seconds", "抢分布式锁的耗时", nil, "client", "result"),
+		failures: NewCounterVec("redis_lock_acquire_failures_total", "没抢到分布式锁的次数", "client", "reason"),
+	}
+	reg.Register(res.duration, res.failures)
+	return res
+}
+
+// Observe key的数量可能很多，不作为label
+func (m *LockMetrics) Observe(key string, duration time.Duration, err error) {
+	result := lockResult(err)
+	m.duration.WithLabelValues(m.name, result).Observe(duration.Seconds())
+	if err != nil {
+		m.failures.WithLabelValues(m.name, result).Inc()
+	}
+}
+
+func lockResult(err error) string {
+	switch {
+	case err == nil:
+		return "acquired"
+	case errors.Is(err, errs.ErrFailedToPreemptLock):
+		return "held"
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return "timeout"
+	default:
+		return "error"
+	}
+}
@@ -0,0 +1,206 @@
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefBuckets 默认的直方图分桶，单位秒，和 Prometheus 官方客户端的一样
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// vec 按label的值区分的一组指标，第一次用到某组label值的时候创建
+type vec[M any] struct {
+	name       string
+	labelNames []string
+	newMetric  func(labels []Label) M
+
+	mutex   sync.RWMutex
+	metrics map[string]M
+	//keys 按创建的顺序输出
+	keys []string
+}
+
+func (v *vec[M]) get(values []string) M {
+	if len(values) != len(v.labelNames) {
+		panic(fmt.Sprintf("metrics:%s 需要%d个label，传了%d个", v.name, len(v.labelNames), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	v.mutex.RLock()
+	m, ok := v.metrics[key]
+	v.mutex.RUnlock()
+	if ok {
+		return m
+	}
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if m, ok = v.metrics[key]; ok {
+		return m
+	}
+	labels := make([]Label, len(values))
+	for i, val := range values {
+		labels[i] = Label{Name: v.labelNames[i], Value: val}
+	}
+	m = v.newMetric(labels)
+	v.metrics[key] = m
+	v.keys = append(v.keys, key)
+	return m
+}
+
+func (v *vec[M]) each(fn func(m M)) {
+	v.mutex.RLock()
+	defer v.mutex.RUnlock()
+	for _, key := range v.keys {
+		fn(v.metrics[key])
+	}
+}
+
+// Counter 只增不减的计数器
+type Counter struct {
+	labels []Label
+	bits   atomic.Uint64
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add v 不能是负数，负数直接忽略
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		return
+	}
+	for {
+		old := c.bits.Load()
+		if c.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+func (c *Counter) Value() float64 {
+	return math.Float64frombits(c.bits.Load())
+}
+
+type CounterVec struct {
+	help string
+	vec[*Counter]
+}
+
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{
+		help: help,
+		vec: vec[*Counter]{
+			name:       name,
+			labelNames: labelNames,
+			metrics:    make(map[string]*Counter),
+			newMetric: func(labels []Label) *Counter {
+				return &Counter{labels: labels}
+			},
+		},
+	}
+}
+
+// WithLabelValues label的值要和 labelNames 一一对应，数量不对会panic
+func (c *CounterVec) WithLabelValues(values ...string) *Counter {
+	return c.get(values)
+}
+
+func (c *CounterVec) Collect() []Family {
+	f := Family{Name: c.name, Help: c.help, Type: CounterType}
+	c.each(func(m *Counter) {
+		f.Samples = append(f.Samples, Sample{Labels: m.labels, Value: m.Value()})
+	})
+	return []Family{f}
+}
+
+// Histogram 分桶统计，比如耗时分布
+type Histogram struct {
+	labels  []Label
+	buckets []float64
+
+	mutex  sync.Mutex
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *Histogram) Observe(v float64) {
+	//第一个大于等于v的桶，输出的时候再累加
+	idx := sort.SearchFloat64s(h.buckets, v)
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if idx < len(h.counts) {
+		h.counts[idx]++
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *Histogram) samples() []Sample {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	res := make([]Sample, 0, len(h.buckets)+3)
+	var cumulative uint64
+	for i, upper := range h.buckets {
+		cumulative += h.counts[i]
+		res = append(res, Sample{
+			Suffix: "_bucket",
+			Labels: withLabel(h.labels, "le", formatValue(upper)),
+			Value:  float64(cumulative),
+		})
+	}
+	res = append(res,
+		Sample{Suffix: "_bucket", Labels: withLabel(h.labels, "le", "+Inf"), Value: float64(h.count)},
+		Sample{Suffix: "_sum", Labels: h.labels, Value: h.sum},
+		Sample{Suffix: "_count", Labels: h.labels, Value: float64(h.count)},
+	)
+	return res
+}
+
+type HistogramVec struct {
+	help string
+	vec[*Histogram]
+}
+
+// NewHistogramVec buckets 为空的时候用 DefBuckets
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefBuckets
+	}
+	sorted := make([]float64, len(buckets))
+	copy(sorted, buckets)
+	sort.Float64s(sorted)
+	return &HistogramVec{
+		help: help,
+		vec: vec[*Histogram]{
+			name:       name,
+			labelNames: labelNames,
+			metrics:    make(map[string]*Histogram),
+			newMetric: func(labels []Label) *Histogram {
+				return &Histogram{labels: labels, buckets: sorted, counts: make([]uint64, len(sorted))}
+			},
+		},
+	}
+}
+
+func (h *HistogramVec) WithLabelValues(values ...string) *Histogram {
+	return h.get(values)
+}
+
+func (h *HistogramVec) Collect() []Family {
+	f := Family{Name: h.name, Help: h.help, Type: HistogramType}
+	h.each(func(m *Histogram) {
+		f.Samples = append(f.Samples, m.samples()...)
+	})
+	return []Family{f}
+}
+
+func withLabel(labels []Label, name, value string) []Label {
+	res := make([]Label, len(labels), len(labels)+1)
+	copy(res, labels)
+	return append(res, Label{Name: name, Value: value})
+}
@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"context"
+	"github.com/xuhaidong1/go-generic-tools/container/queue"
+	"time"
+)
+
+// InstrumentedQueue 给队列打点的装饰器，ConcurrentBlockingQueue、DelayQueue 这些实现了 queue.Queue 的都能用
+type InstrumentedQueue[T any] struct {
+	queue.Queue[T]
+	name   string
+	wait   *HistogramVec
+	errors *CounterVec
+}
+
+// InstrumentQueue 把 q 包一层，指标注册到 reg 上，name 作为 queue 这个label。输出：
+// queue_depth 抓取的时候读 Len()；
+// queue_wait_seconds{op} Enqueue/Dequeue 的阻塞时间，比如队列满了生产者要等，队列空了或者延时没到消费者要等；
+// queue_operation_errors_total{op} 超时之类的失败次数
+func InstrumentQueue[T any](reg *Registry, name string, q queue.Queue[T]) *InstrumentedQueue[T] {
+	res := &InstrumentedQueue[T]{
+		Queue:  q,
+		name:   name,
+		wait:   NewHistogramVec("queue_wait_seconds", "队列入队出队的阻塞时间", nil, "queue", "op"),
+		errors: NewCounterVec("queue_operation_errors_total", "队列入队出队失败的次数", "queue", "op"),
+	}
+	reg.Register(res.wait, res.errors, CollectorFunc(func() []Family {
+		return []Family{{Name: "queue_depth", Help: "队列里当前元素的数量", Type: GaugeType,
+			Samples: []Sample{{Labels: []Label{{Name: "queue", Value: name}}, Value: float64(q.Len())}}}}
+	}))
+	return res
+}
+
+func (q *InstrumentedQueue[T]) Enqueue(ctx context.Context, data T) error {
+	start := time.Now()
+	err := q.Queue.Enqueue(ctx, data)
+	q.observe("enqueue", start, err)
+	return err
+}
+
+func (q *InstrumentedQueue[T]) Dequeue(ctx context.Context) (T, error) {
+	start := time.Now()
+	res, err := q.Queue.Dequeue(ctx)
+	q.observe("dequeue", start, err)
+	return res, err
+}
+
+func (q *InstrumentedQueue[T]) observe(op string, start time.Time, err error) {
+	q.wait.WithLabelValues(q.name, op).Observe(time.Since(start).Seconds())
+	if err != nil {
+		q.errors.WithLabelValues(q.name, op).Inc()
+	}
+}
@@ -0,0 +1,186 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// MetricType 对应 Prometheus 文本格式里的 # TYPE
+type MetricType string
+
+const (
+	CounterType   MetricType = "counter"
+	GaugeType     MetricType = "gauge"
+	HistogramType MetricType = "histogram"
+)
+
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample 一行数据，Suffix 是拼在指标名后面的，比如直方图的 _bucket、_sum、_count
+type Sample struct {
+	Suffix string
+	Labels []Label
+	Value  float64
+}
+
+// Family 同名的一组数据，输出的时候共用一个 HELP 和 TYPE
+type Family struct {
+	Name    string
+	Help    string
+	Type    MetricType
+	Samples []Sample
+}
+
+// Collector 每次抓取的时候调用一次，返回当前的数据
+type Collector interface {
+	Collect() []Family
+}
+
+// CollectorFunc 抓取的时候现算，比如读队列长度、缓存的统计
+type CollectorFunc func() []Family
+
+func (f CollectorFunc) Collect() []Family {
+	return f()
+}
+
+// Registry 不依赖第三方库的指标注册中心，本身就是一个 http.Handler，挂到 /metrics 上就能被 Prometheus 抓取
+type Registry struct {
+	mutex      sync.RWMutex
+	collectors []Collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) Register(collectors ...Collector) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.collectors = append(r.collectors, collectors...)
+}
+
+// Gather 收集所有数据，同名的合并到一起（比如多个缓存都有 cache_hits_total，靠label区分），按名字排序。
+// 同名但是类型不一样的以先注册的为准，后面的丢掉
+func (r *Registry) Gather() []Family {
+	r.mutex.RLock()
+	collectors := make([]Collector, len(r.collectors))
+	copy(collectors, r.collectors)
+	r.mutex.RUnlock()
+
+	families := make(map[string]*Family)
+	for _, c := range collectors {
+		for _, f := range c.Collect() {
+			exist, ok := families[f.Name]
+			if !ok {
+				f := f
+				families[f.Name] = &f
+				continue
+			}
+			if exist.Type == f.Type {
+				exist.Samples = append(exist.Samples, f.Samples...)
+			}
+		}
+	}
+	res := make([]Family, 0, len(families))
+	for _, f := range families {
+		res = append(res, *f)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// WriteTo 按 Prometheus 文本格式（0.0.4）输出
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	cw := &countWriter{w: bufio.NewWriter(w)}
+	for _, f := range r.Gather() {
+		if len(f.Samples) == 0 {
+			continue
+		}
+		if f.Help != "" {
+			cw.writeString("# HELP " + f.Name + " " + escapeHelp(f.Help) + "\n")
+		}
+		cw.writeString("# TYPE " + f.Name + " " + string(f.Type) + "\n")
+		for _, s := range f.Samples {
+			cw.writeString(f.Name + s.Suffix)
+			writeLabels(cw, s.Labels)
+			cw.writeString(" " + formatValue(s.Value) + "\n")
+		}
+	}
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+	return cw.n, cw.w.Flush()
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	//写一半失败了也没办法告诉对方了
+	_, _ = r.WriteTo(w)
+}
+
+func writeLabels(cw *countWriter, labels []Label) {
+	if len(labels) == 0 {
+		return
+	}
+	cw.writeString("{")
+	for i, l := range labels {
+		if i > 0 {
+			cw.writeString(",")
+		}
+		cw.writeString(l.Name + `="` + escapeLabelValue(l.Value) + `"`)
+	}
+	cw.writeString("}")
+}
+
+var (
+	helpReplacer  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return labelReplacer.Replace(s)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+// countWriter 出错之后就不再写了，最后统一返回错误
+type countWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (c *countWriter) writeString(s string) {
+	if c.err != nil {
+		return
+	}
+	n, err := c.w.WriteString(s)
+	c.n += int64(n)
+	c.err = err
+}
@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_WriteTo(t *testing.T) {
+	reg := NewRegistry()
+	counter := NewCounterVec("jobs_total", "处理的任务数\n多行", "type")
+	counter.WithLabelValues("a").Inc()
+	counter.WithLabelValues(`b"\`).Add(2.5)
+	hist := NewHistogramVec("latency_seconds", "", []float64{1, 0.1}, "op")
+	hist.WithLabelValues("get").Observe(0.05)
+	hist.WithLabelValues("get").Observe(0.1)
+	hist.WithLabelValues("get").Observe(3)
+	reg.Register(counter, hist)
+
+	var sb strings.Builder
+	_, err := reg.WriteTo(&sb)
+	require.NoError(t, err)
+	assert.Equal(t, `# HELP jobs_total 处理的任务数\n多行
+# TYPE jobs_total counter
+jobs_total{type="a"} 1
+jobs_total{type="b\"\\"} 2.5
+# TYPE latency_seconds histogram
+latency_seconds_bucket{op="get",le="0.1"} 2
+latency_seconds_bucket{op="get",le="1"} 2
+latency_seconds_bucket{op="get",le="+Inf"} 3
+latency_seconds_sum{op="get"} 3.15
+latency_seconds_count{op="get"} 3
+`, sb.String())
+}
+
+func TestRegistry_MergeFamilies(t *testing.T) {
+	reg := NewRegistry()
+	newCollector := func(name string, typ MetricType) Collector {
+		return CollectorFunc(func() []Family {
+			return []Family{{Name: "depth", Help: "深度", Type: typ,
+				Samples: []Sample{{Labels: []Label{{Name: "queue", Value: name}}, Value: 1}}}}
+		})
+	}
+	// 同名的合并到一起，类型不一样的丢掉
+	reg.Register(newCollector("q1", GaugeType), newCollector("q2", GaugeType), newCollector("q3", CounterType))
+	// 没有数据的不输出
+	reg.Register(NewCounterVec("empty_total", "空的"))
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	recorder := httptest.NewRecorder()
+	reg.ServeHTTP(recorder, req)
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, `# HELP depth 深度
+# TYPE depth gauge
+depth{queue="q1"} 1
+depth{queue="q2"} 1
+`, recorder.Body.String())
+}
+
+func TestCounterVec_WrongLabels(t *testing.T) {
+	counter := NewCounterVec("jobs_total", "", "type")
+	assert.Panics(t, func() {
+		counter.WithLabelValues("a", "b")
+	})
+	counter.WithLabelValues("a").Add(-1)
+	assert.Equal(t, float64(0), counter.WithLabelValues("a").Value())
+}
@@ -5,4 +5,6 @@ import "errors"
 var (
 	ErrLockNotHold         = errors.New("没有持有锁")
 	ErrFailedToPreemptLock = errors.New("没有抢到锁")
+	//ErrLockUnavailable 连不上redis之类的错误，不知道锁在不在别人手里
+	ErrLockUnavailable = errors.New("加锁请求失败")
 )
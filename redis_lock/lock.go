@@ -25,10 +25,15 @@ type RetryStrategy interface {
 }
 
 type Client struct {
-	client redis.Cmdable
-	retry  RetryStrategy
+	client   redis.Cmdable
+	retry    RetryStrategy
+	observer AcquireObserver
 }
 
+// AcquireObserver 每次 Lock/TryLock 结束的时候回调，err 为nil表示抢到了锁，
+// duration 是抢锁花的时间，Lock 的话包括重试。用来打点，不要在里面做耗时的事情
+type AcquireObserver func(key string, duration time.Duration, err error)
+
 func NewClient(client redis.Cmdable, opts ...Options) *Client {
 	c := &Client{client: client}
 	for _, opt := range opts {
@@ -45,6 +50,13 @@ func WithRetryStrategy(r RetryStrategy) Options {
 	}
 }
 
+// WithAcquireObserver 监控抢锁的耗时和失败，比如 metrics.NewLockMetrics(reg, "order").Observe
+func WithAcquireObserver(o AcquireObserver) Options {
+	return func(c *Client) {
+		c.observer = o
+	}
+}
+
 type Lock struct {
 	client     redis.Cmdable
 	key        string
@@ -57,6 +69,13 @@ type Lock struct {
 // Lock 加锁重试，可以注入重试策略
 // timeout：抢锁的超时时间
 func (c *Client) Lock(ctx context.Context, key, val string, expiration, timeout time.Duration) (*Lock, error) {
+	start := time.Now()
+	l, err := c.lock(ctx, key, val, expiration, timeout)
+	c.observe(key, start, err)
+	return l, err
+}
+
+func (c *Client) lock(ctx context.Context, key, val string, expiration, timeout time.Duration) (*Lock, error) {
 	if ctx.Err() != nil {
 		return nil, ctx.Err()
 	}
@@ -101,9 +120,17 @@ func (c *Client) Lock(ctx context.Context, key, val string, expiration, timeout
 }
 
 func (c *Client) TryLock(ctx context.Context, key, val string, expiration time.Duration) (*Lock, error) {
+	start := time.Now()
+	l, err := c.tryLock(ctx, key, val, expiration)
+	c.observe(key, start, err)
+	return l, err
+}
+
+// tryLock 锁在别人手里返回 ErrFailedToPreemptLock，redis 出错返回 ErrLockUnavailable，调用方可以区分开
+func (c *Client) tryLock(ctx context.Context, key, val string, expiration time.Duration) (*Lock, error) {
 	res, err := c.client.Eval(ctx, luaLock, []string{key}, val, expiration.Seconds()).Result()
 	if err != nil {
-		return nil, errs.ErrFailedToPreemptLock
+		return nil, fmt.Errorf("%w: %w", errs.ErrLockUnavailable, err)
 	}
 	if res == "OK" {
 		return &Lock{
@@ -117,6 +144,12 @@ func (c *Client) TryLock(ctx context.Context, key, val string, expiration time.D
 	return nil, errs.ErrFailedToPreemptLock
 }
 
+func (c *Client) observe(key string, start time.Time, err error) {
+	if c.observer != nil {
+		c.observer(key, time.Since(start), err)
+	}
+}
+
 func (l *Lock) Unlock(ctx context.Context) error {
 	l.unlockOnce.Do(func() {
 		close(l.unlock)